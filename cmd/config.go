@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hw6/internal/dbexplorer"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "DBEXPLORER_"
+
+// Config is read from defaults, then a JSON or YAML file, then DBEXPLORER_* env
+// vars, then command line flags, each overriding the previous one.
+// Per-table options can only be set in the file.
+type Config struct {
+	DSN    string `json:"dsn"`
+	Listen string `json:"listen"`
+
+	// ReplicaDSN, when set, serves table and row reads
+	ReplicaDSN string `json:"replica_dsn"`
+
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	IdleTimeout  Duration `json:"idle_timeout"`
+
+	MaxAffectedRows int    `json:"max_affected_rows"`
+	CacheControl    string `json:"cache_control"`
+	StrictFields    bool   `json:"strict_fields"`
+	RequireFields   bool   `json:"require_fields"`
+	ReadOnly        bool   `json:"read_only"`
+	Maintenance     string `json:"maintenance"`
+	MaxPageSize     int    `json:"max_page_size"`
+	PathPrefix      string `json:"path_prefix"`
+
+	IncludeTables []string               `json:"include_tables"`
+	ExcludeTables []string               `json:"exclude_tables"`
+	Tables        map[string]TableConfig `json:"tables"`
+
+	// APIKeys maps each key to the principal it authenticates
+	APIKeys map[string]dbexplorer.Principal `json:"api_keys"`
+	JWT     JWTConfig                       `json:"jwt"`
+
+	// Policies grant roles operations on tables, when there are none
+	// every authenticated caller may do everything
+	Policies  []dbexplorer.Policy `json:"policies"`
+	AdminRole string              `json:"admin_role"`
+
+	Audit AuditConfig `json:"audit"`
+}
+
+// AuditConfig picks one sink: a table in the database or a JSONL file
+type AuditConfig struct {
+	Table string `json:"table"`
+	File  string `json:"file"`
+}
+
+type JWTConfig struct {
+	Secret     string   `json:"secret"`
+	JWKSFile   string   `json:"jwks_file"`
+	Issuer     string   `json:"issuer"`
+	Audience   string   `json:"audience"`
+	RolesClaim string   `json:"roles_claim"`
+	Leeway     Duration `json:"leeway"`
+}
+
+func (c JWTConfig) enabled() bool {
+	return c.Secret != "" || c.JWKSFile != ""
+}
+
+// TableConfig keys are table names; column lists also accept patterns
+// like "*" to cover several tables
+type TableConfig struct {
+	SoftDelete       string   `json:"soft_delete"`
+	VersionColumn    string   `json:"version_column"`
+	HiddenColumns    []string `json:"hidden_columns"`
+	ReadOnlyColumns  []string `json:"read_only_columns"`
+	WriteOnlyColumns []string `json:"write_only_columns"`
+	// RowFilter is a predicate like "tenant_id = {claims.tenant}"
+	RowFilter string `json:"row_filter"`
+	// Masks maps column names to their masking
+	Masks map[string]MaskConfig `json:"masks"`
+}
+
+type MaskConfig struct {
+	// Strategy is one of redact, hash, partial or null
+	Strategy    dbexplorer.Masking `json:"strategy"`
+	ExceptRoles []string           `json:"except_roles"`
+}
+
+// Duration accepts "30s"-style strings in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// setting is an option that can come from the file, the environment and
+// a flag; the env var name is derived from the flag name
+type setting struct {
+	name   string
+	usage  string
+	isBool bool
+	set    func(c *Config, value string) error
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{name: name, usage: usage, isBool: true, set: func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+// listSetting takes a comma separated list
+func listSetting(name, usage string, field func(c *Config) *[]string) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, value string) error {
+		*field(c) = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field(c) = append(*field(c), item)
+			}
+		}
+		return nil
+	}}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *Duration) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 5m", value)
+		}
+		field(c).Duration = d
+		return nil
+	}}
+}
+
+var settings = []setting{
+	stringSetting("dsn", "MySQL DSN", func(c *Config) *string { return &c.DSN }),
+	stringSetting("listen", "address to listen on", func(c *Config) *string { return &c.Listen }),
+	intSetting("max-open-conns", "maximum open database connections, 0 is unlimited", func(c *Config) *int { return &c.MaxOpenConns }),
+	intSetting("max-idle-conns", "maximum idle database connections", func(c *Config) *int { return &c.MaxIdleConns }),
+	durationSetting("conn-max-lifetime", "maximum lifetime of a database connection, 0 is unlimited", func(c *Config) *Duration { return &c.ConnMaxLifetime }),
+	durationSetting("read-timeout", "HTTP read timeout", func(c *Config) *Duration { return &c.ReadTimeout }),
+	durationSetting("write-timeout", "HTTP write timeout", func(c *Config) *Duration { return &c.WriteTimeout }),
+	durationSetting("idle-timeout", "HTTP keep-alive idle timeout", func(c *Config) *Duration { return &c.IdleTimeout }),
+	intSetting("max-affected-rows", "row limit for filtered updates and deletes, 0 disables it", func(c *Config) *int { return &c.MaxAffectedRows }),
+	stringSetting("cache-control", "Cache-Control header for reads", func(c *Config) *string { return &c.CacheControl }),
+	boolSetting("strict-fields", "reject unknown fields in write bodies", func(c *Config) *bool { return &c.StrictFields }),
+	boolSetting("require-fields", "require NOT NULL columns without defaults on insert", func(c *Config) *bool { return &c.RequireFields }),
+	boolSetting("read-only", "reject all writes", func(c *Config) *bool { return &c.ReadOnly }),
+	stringSetting("maintenance", "start with writes paused for this reason, resumed through PUT /_maintenance", func(c *Config) *string { return &c.Maintenance }),
+	stringSetting("replica-dsn", "MySQL DSN of a replica serving reads", func(c *Config) *string { return &c.ReplicaDSN }),
+	intSetting("max-page-size", "maximum limit for table reads, 0 is unlimited", func(c *Config) *int { return &c.MaxPageSize }),
+	listSetting("include-tables", "comma separated table names or patterns to expose, default all", func(c *Config) *[]string { return &c.IncludeTables }),
+	listSetting("exclude-tables", "comma separated table names or patterns to hide", func(c *Config) *[]string { return &c.ExcludeTables }),
+	stringSetting("jwt-secret", "HS256 secret for bearer tokens", func(c *Config) *string { return &c.JWT.Secret }),
+	stringSetting("jwt-jwks-file", "JWKS file with HS256 or RS256 keys for bearer tokens", func(c *Config) *string { return &c.JWT.JWKSFile }),
+	stringSetting("jwt-issuer", "required iss claim of bearer tokens", func(c *Config) *string { return &c.JWT.Issuer }),
+	stringSetting("jwt-audience", "required aud claim of bearer tokens", func(c *Config) *string { return &c.JWT.Audience }),
+	stringSetting("audit-table", "record changes to this table, created if missing", func(c *Config) *string { return &c.Audit.Table }),
+	stringSetting("audit-file", "record changes to this JSONL file", func(c *Config) *string { return &c.Audit.File }),
+	stringSetting("path-prefix", "serve the API under this path, e.g. /db", func(c *Config) *string { return &c.PathPrefix }),
+}
+
+func defaultConfig() Config {
+	return Config{
+		DSN:             DSN,
+		Listen:          ":8082",
+		MaxIdleConns:    2,
+		ReadTimeout:     Duration{10 * time.Second},
+		WriteTimeout:    Duration{30 * time.Second},
+		IdleTimeout:     Duration{60 * time.Second},
+		MaxAffectedRows: 1000,
+	}
+}
+
+// LoadConfig builds the configuration from args (without the program name)
+// and the environment, returning flag.ErrHelp when -h was requested
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	fs := flag.NewFlagSet("db-explorer", flag.ContinueOnError)
+	configPath := fs.String("config", getenv(envPrefix+"CONFIG"), "path to a JSON or YAML config file (env "+envPrefix+"CONFIG)")
+
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env())
+		record := func(value string) error {
+			flagValues[s.name] = value
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.name, usage, record)
+		} else {
+			fs.Func(s.name, usage, record)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		value := getenv(s.env())
+		if value == "" {
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			return Config{}, fmt.Errorf("env %s: %w", s.env(), err)
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flagValues[s.name]
+		if !ok {
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			return Config{}, fmt.Errorf("flag -%s: %w", s.name, err)
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// loadFile reads a JSON file, or YAML when the name ends in .yaml or .yml;
+// YAML is converted to JSON first so both formats share the json tags
+// and reject unknown keys the same way
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.DSN == "" {
+		errs = append(errs, errors.New("dsn is required"))
+	}
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	if c.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("max_open_conns can't be negative"))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("max_idle_conns can't be negative"))
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("max_idle_conns (%d) can't exceed max_open_conns (%d)", c.MaxIdleConns, c.MaxOpenConns))
+	}
+	if c.MaxAffectedRows < 0 {
+		errs = append(errs, errors.New("max_affected_rows can't be negative"))
+	}
+	if c.MaxPageSize < 0 {
+		errs = append(errs, errors.New("max_page_size can't be negative"))
+	}
+	if c.PathPrefix != "" && !strings.HasPrefix(c.PathPrefix, "/") {
+		errs = append(errs, errors.New("path_prefix must start with /"))
+	}
+
+	durations := map[string]Duration{
+		"conn_max_lifetime": c.ConnMaxLifetime,
+		"read_timeout":      c.ReadTimeout,
+		"write_timeout":     c.WriteTimeout,
+		"idle_timeout":      c.IdleTimeout,
+	}
+	for name, d := range durations {
+		if d.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%s can't be negative", name))
+		}
+	}
+
+	if c.Audit.Table != "" && c.Audit.File != "" {
+		errs = append(errs, errors.New("audit: set either table or file"))
+	}
+	if c.JWT.Leeway.Duration < 0 {
+		errs = append(errs, errors.New("jwt.leeway can't be negative"))
+	}
+	if _, ok := c.APIKeys[""]; ok {
+		errs = append(errs, errors.New("api_keys: empty key"))
+	}
+
+	if _, ok := c.Tables[""]; ok {
+		errs = append(errs, errors.New("tables: empty table name"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ExplorerOptions fails when the JWKS file can't be loaded
+// or the audit sink can't be set up
+func (c Config) ExplorerOptions(db *sql.DB) ([]dbexplorer.Option, error) {
+	opts := []dbexplorer.Option{
+		dbexplorer.WithMaxAffectedRows(c.MaxAffectedRows),
+		dbexplorer.WithCacheControl(c.CacheControl),
+		dbexplorer.WithMaxPageSize(c.MaxPageSize),
+		dbexplorer.WithPathPrefix(c.PathPrefix),
+		dbexplorer.WithLogger(slog.Default()),
+	}
+	if c.ReadOnly {
+		opts = append(opts, dbexplorer.WithReadOnly())
+	}
+	if c.Maintenance != "" {
+		opts = append(opts, dbexplorer.WithMaintenance(c.Maintenance))
+	}
+	if len(c.IncludeTables) > 0 {
+		opts = append(opts, dbexplorer.WithTables(c.IncludeTables...))
+	}
+	if len(c.ExcludeTables) > 0 {
+		opts = append(opts, dbexplorer.WithExcludeTables(c.ExcludeTables...))
+	}
+	if c.StrictFields {
+		opts = append(opts, dbexplorer.WithStrictFields())
+	}
+	if c.RequireFields {
+		opts = append(opts, dbexplorer.WithRequiredFields())
+	}
+
+	for name, table := range c.Tables {
+		if table.SoftDelete != "" {
+			opts = append(opts, dbexplorer.WithSoftDelete(name, table.SoftDelete))
+		}
+		if table.VersionColumn != "" {
+			opts = append(opts, dbexplorer.WithVersionColumn(name, table.VersionColumn))
+		}
+		if table.RowFilter != "" {
+			opts = append(opts, dbexplorer.WithRowFilter(name, table.RowFilter))
+		}
+		for column, mask := range table.Masks {
+			opts = append(opts, dbexplorer.WithMasking(name, column, mask.Strategy, mask.ExceptRoles...))
+		}
+		if len(table.HiddenColumns) > 0 {
+			opts = append(opts, dbexplorer.WithHiddenColumns(name, table.HiddenColumns...))
+		}
+		if len(table.ReadOnlyColumns) > 0 {
+			opts = append(opts, dbexplorer.WithReadOnlyColumns(name, table.ReadOnlyColumns...))
+		}
+		if len(table.WriteOnlyColumns) > 0 {
+			opts = append(opts, dbexplorer.WithWriteOnlyColumns(name, table.WriteOnlyColumns...))
+		}
+	}
+
+	var authenticators []dbexplorer.Authenticator
+	if len(c.APIKeys) > 0 {
+		authenticators = append(authenticators, dbexplorer.NewAPIKeyAuthenticator(c.APIKeys))
+	}
+	if c.JWT.enabled() {
+		auth, err := dbexplorer.NewJWTAuthenticator(dbexplorer.JWTConfig{
+			Secret:     []byte(c.JWT.Secret),
+			JWKSFile:   c.JWT.JWKSFile,
+			Issuer:     c.JWT.Issuer,
+			Audience:   c.JWT.Audience,
+			RolesClaim: c.JWT.RolesClaim,
+			Leeway:     c.JWT.Leeway.Duration,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
+	}
+	if len(c.Policies) > 0 {
+		opts = append(opts, dbexplorer.WithPolicies(c.Policies...))
+	}
+	if c.AdminRole != "" {
+		opts = append(opts, dbexplorer.WithAdminRole(c.AdminRole))
+	}
+	switch {
+	case c.Audit.Table != "":
+		sink, err := dbexplorer.NewTableAuditSink(db, c.Audit.Table)
+		if err != nil {
+			return nil, fmt.Errorf("audit table: %w", err)
+		}
+		opts = append(opts, dbexplorer.WithAudit(sink))
+	case c.Audit.File != "":
+		sink, err := dbexplorer.NewJSONLAuditSink(c.Audit.File)
+		if err != nil {
+			return nil, fmt.Errorf("audit file: %w", err)
+		}
+		opts = append(opts, dbexplorer.WithAudit(sink))
+	}
+
+	if len(authenticators) > 0 {
+		opts = append(opts, dbexplorer.WithAuthenticator(authenticators...))
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	jsonFile := writeFile("config.json", `{"listen": ":9000", "max_page_size": 50, "read_timeout": "5s"}`)
+	yamlFile := writeFile("config.yaml", "listen: \":9100\"\nmax_page_size: 60\ntables:\n  users:\n    hidden_columns: [password]\n")
+	unknownFile := writeFile("unknown.json", `{"listne": ":9000"}`)
+
+	cases := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(c Config) bool
+		wantErr string
+	}{
+		{
+			name:  "defaults",
+			check: func(c Config) bool { return c.Listen == ":8082" && c.MaxAffectedRows == 1000 },
+		},
+		{
+			name: "json file overrides defaults",
+			args: []string{"-config", jsonFile},
+			check: func(c Config) bool {
+				return c.Listen == ":9000" && c.MaxPageSize == 50 && c.ReadTimeout.Duration == 5*time.Second
+			},
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", yamlFile},
+			check: func(c Config) bool {
+				return c.Listen == ":9100" && c.MaxPageSize == 60 && c.Tables["users"].HiddenColumns[0] == "password"
+			},
+		},
+		{
+			name:  "config path from env",
+			env:   map[string]string{"DBEXPLORER_CONFIG": yamlFile},
+			check: func(c Config) bool { return c.Listen == ":9100" },
+		},
+		{
+			name:  "env overrides file",
+			args:  []string{"-config", jsonFile},
+			env:   map[string]string{"DBEXPLORER_LISTEN": ":9200"},
+			check: func(c Config) bool { return c.Listen == ":9200" && c.MaxPageSize == 50 },
+		},
+		{
+			name:  "flag overrides env",
+			args:  []string{"-config", jsonFile, "-listen", ":9300", "-read-only"},
+			env:   map[string]string{"DBEXPLORER_LISTEN": ":9200"},
+			check: func(c Config) bool { return c.Listen == ":9300" && c.ReadOnly },
+		},
+		{
+			name:    "invalid env duration",
+			env:     map[string]string{"DBEXPLORER_READ_TIMEOUT": "soon"},
+			wantErr: "env DBEXPLORER_READ_TIMEOUT",
+		},
+		{
+			name:    "invalid flag number",
+			args:    []string{"-max-page-size", "many"},
+			wantErr: "flag -max-page-size",
+		},
+		{
+			name:    "unknown file key",
+			args:    []string{"-config", unknownFile},
+			wantErr: `unknown field "listne"`,
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-config", filepath.Join(dir, "missing.yaml")},
+			wantErr: "config file",
+		},
+		{
+			name:    "idle above open connections",
+			args:    []string{"-max-open-conns", "2", "-max-idle-conns", "5"},
+			wantErr: "max_idle_conns (5) can't exceed max_open_conns (2)",
+		},
+		{
+			name:    "negative page size",
+			args:    []string{"-max-page-size", "-1"},
+			wantErr: "max_page_size can't be negative",
+		},
+		{
+			name:    "relative path prefix",
+			args:    []string{"-path-prefix", "db"},
+			wantErr: "path_prefix must start with /",
+		},
+		{
+			name:    "two audit sinks",
+			args:    []string{"-audit-table", "audit", "-audit-file", "audit.jsonl"},
+			wantErr: "audit: set either table or file",
+		},
+		{
+			name:    "errors are reported together",
+			args:    []string{"-dsn", "", "-listen", ""},
+			wantErr: "dsn is required\nlisten address is required",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := LoadConfig(tc.args, func(name string) string { return tc.env[name] })
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.check(cfg) {
+				t.Fatalf("unexpected config: %+v", cfg)
+			}
+		})
+	}
+}
@@ -3,38 +3,87 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"hw6/internal/dbexplorer"
 	"log"
 	"net/http"
+	"os"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var (
-	// DSN это соединение с базой
-	// Вы можете изменить его на то, которое вам нужно
+	// DSN это соединение с базой по умолчанию
+	// Его можно переопределить флагом -dsn, переменной DBEXPLORER_DSN или в конфиге
 	// docker run -p 3306:3306 -v $(PWD):/docker-entrypoint-initdb.d -e MYSQL_ROOT_PASSWORD=1234 -e MYSQL_DATABASE=golang -d mysql
 	DSN = "root:1234@tcp(localhost:3306)/golang?charset=utf8"
 )
 
 func main() {
-	db, err := sql.Open("mysql", DSN)
+	cfg, err := LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		panic(err)
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	err = db.Ping() // Тут будет первое подключение к базе
+
+	db, err := openDB(cfg, cfg.DSN) // Тут будет первое подключение к базе
+	if err != nil {
+		log.Fatalf("can't connect to database: %v", err)
+	}
+
+	opts, err := cfg.ExplorerOptions(db)
 	if err != nil {
-		panic(err)
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	if cfg.ReplicaDSN != "" {
+		replica, err := openDB(cfg, cfg.ReplicaDSN)
+		if err != nil {
+			log.Fatalf("can't connect to replica: %v", err)
+		}
+		opts = append(opts, dbexplorer.WithReadReplica(replica))
+	}
+
+	if len(cfg.APIKeys) == 0 && !cfg.JWT.enabled() {
+		log.Println("warning: no api_keys or jwt configured, the API is open to anyone")
 	}
 
-	handler, err := dbexplorer.NewDBExplorer(db) //nolint:typecheck
+	handler, err := dbexplorer.NewDBExplorer(db, opts...) //nolint:typecheck
 	if err != nil {
-		panic(err)
+		log.Fatalf("can't load database schema: %v", err)
 	}
 
-	fmt.Println("starting server at :8082")
-	if err := http.ListenAndServe(":8082", handler); err != nil {
+	server := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+	}
+
+	fmt.Println("starting server at", cfg.Listen)
+	if err := server.ListenAndServe(); err != nil {
 		log.Printf("error listenAndServer: %v", err)
 	}
 }
+
+// openDB applies the pool settings and checks the connection
+func openDB(cfg Config, dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -2,6 +2,9 @@ module hw6
 
 go 1.25.1
 
-require github.com/go-sql-driver/mysql v1.9.3
+require (
+	github.com/go-sql-driver/mysql v1.9.3
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require filippo.io/edwards25519 v1.1.0 // indirect
@@ -0,0 +1,120 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"path"
+	"slices"
+)
+
+type columnAccess int
+
+const (
+	accessReadWrite columnAccess = iota
+	accessHidden
+	accessReadOnly
+	accessWriteOnly
+)
+
+// columnRule applies an access level to columns of every table
+// matching Table, a path.Match pattern such as "*" or "audit_*"
+type columnRule struct {
+	Table   string
+	Columns []string
+	Access  columnAccess
+}
+
+func ErrReadOnlyField(colName string) error {
+	return fmt.Errorf("field %s is read-only", colName)
+}
+
+// readable columns are served in records and can be filtered on
+func (c column) readable() bool {
+	return c.Access == accessReadWrite || c.Access == accessReadOnly
+}
+
+// writable columns can be set through request bodies
+func (c column) writable() bool {
+	return c.Access == accessReadWrite || c.Access == accessWriteOnly
+}
+
+// exposeTable reports whether a table passes the include and exclude
+// patterns; with no include patterns every table is included
+func (h *handler) exposeTable(name string) (bool, error) {
+	included := len(h.includeTables) == 0
+	for _, pattern := range h.includeTables {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("table pattern %q: %w", pattern, err)
+		}
+		included = included || ok
+	}
+
+	for _, pattern := range h.excludeTables {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("table pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	return included, nil
+}
+
+// applyColumnRules sets the access level of the table's columns; a rule
+// naming the table exactly must only list existing columns, so a typo
+// can't leave a sensitive column exposed
+func (h *handler) applyColumnRules(t *table) error {
+	for _, rule := range h.columnRules {
+		ok, err := path.Match(rule.Table, t.Name)
+		if err != nil {
+			return fmt.Errorf("table pattern %q: %w", rule.Table, err)
+		}
+		if !ok {
+			continue
+		}
+
+		for _, colName := range rule.Columns {
+			i := slices.IndexFunc(t.Columns, func(c column) bool { return c.Name == colName })
+			if i < 0 {
+				if rule.Table == t.Name {
+					return ErrUnknownColumn(t.Name, colName)
+				}
+				continue
+			}
+			if colName == t.PrimaryKeyName && rule.Access != accessReadOnly {
+				return fmt.Errorf("primary key %s of table %s must stay readable", colName, t.Name)
+			}
+			t.Columns[i].Access = rule.Access
+		}
+	}
+	return nil
+}
+
+func hiddenColumn(t table, name string) bool {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col.Access == accessHidden
+		}
+	}
+	return false
+}
+
+func hasWritableColumn(t table, name string) bool {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col.writable()
+		}
+	}
+	return false
+}
+
+// readableColumn looks up a column the API may show
+func readableColumn(t table, name string) (column, bool) {
+	for _, col := range t.Columns {
+		if col.Name == name && col.readable() {
+			return col, true
+		}
+	}
+	return column{}, false
+}
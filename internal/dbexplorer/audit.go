@@ -0,0 +1,456 @@
+package dbexplorer
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditEntry records one modified row; Before is empty for creates
+// and After for hard deletes
+type AuditEntry struct {
+	Time      time.Time      `json:"time"`
+	RequestID string         `json:"request_id"`
+	Principal string         `json:"principal"`
+	Action    string         `json:"action"`
+	Table     string         `json:"table"`
+	Key       string         `json:"key"`
+	Before    map[string]any `json:"before,omitempty"`
+	After     map[string]any `json:"after,omitempty"`
+}
+
+// AuditFilter selects entries for GET /_audit, zero fields match anything
+type AuditFilter struct {
+	Table     string
+	Key       string
+	Principal string
+	Action    string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Offset    int
+	// Ascending returns the oldest entries first instead of the newest
+	Ascending bool
+}
+
+// AuditSink stores entries; Write is called once per request
+// after the changes are committed
+type AuditSink interface {
+	Write(ctx context.Context, entries []AuditEntry) error
+}
+
+// TxAuditSink stores entries in the transaction making the changes
+// instead, so they are committed together or not at all
+type TxAuditSink interface {
+	AuditSink
+	WriteTx(ctx context.Context, tx *sql.Tx, entries []AuditEntry) error
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// AuditReader is implemented by sinks that can serve GET /_audit
+type AuditReader interface {
+	Read(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	switch {
+	case f.Table != "" && e.Table != f.Table,
+		f.Key != "" && e.Key != f.Key,
+		f.Principal != "" && e.Principal != f.Principal,
+		f.Action != "" && e.Action != f.Action,
+		f.RequestID != "" && e.RequestID != f.RequestID,
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// JSONLAuditSink appends entries to a file, one JSON object per line
+type JSONLAuditSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewJSONLAuditSink(path string) (*JSONLAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLAuditSink{path: path}, file.Close()
+}
+
+func (s *JSONLAuditSink) Write(ctx context.Context, entries []AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// Read scans the whole file
+func (s *JSONLAuditSink) Read(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matched []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if filter.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !filter.Ascending {
+		slices.Reverse(matched)
+	}
+	matched = matched[min(filter.Offset, len(matched)):]
+	return append([]AuditEntry{}, matched[:min(filter.Limit, len(matched))]...), nil
+}
+
+// TableAuditSink stores entries in a table of the explored database,
+// the table is created if needed and never exposed by the explorer
+type TableAuditSink struct {
+	db    *sql.DB
+	table string
+}
+
+func NewTableAuditSink(db *sql.DB, table string) (*TableAuditSink, error) {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id bigint NOT NULL AUTO_INCREMENT,
+		time datetime(6) NOT NULL,
+		request_id varchar(64) NOT NULL,
+		principal varchar(255) NOT NULL,
+		action varchar(16) NOT NULL,
+		table_name varchar(255) NOT NULL,
+		row_key varchar(255) NOT NULL,
+		before_values text,
+		after_values text,
+		PRIMARY KEY (id)
+	);`, table))
+	if err != nil {
+		return nil, err
+	}
+	return &TableAuditSink{db: db, table: table}, nil
+}
+
+func (s *TableAuditSink) TableName() string {
+	return s.table
+}
+
+func (s *TableAuditSink) Write(ctx context.Context, entries []AuditEntry) error {
+	return s.write(ctx, s.db, entries)
+}
+
+func (s *TableAuditSink) WriteTx(ctx context.Context, tx *sql.Tx, entries []AuditEntry) error {
+	return s.write(ctx, tx, entries)
+}
+
+func (s *TableAuditSink) write(ctx context.Context, db execer, entries []AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tuples := ""
+	args := make([]any, 0, len(entries)*8)
+	for i, e := range entries {
+		before, err := marshalValues(e.Before)
+		if err != nil {
+			return err
+		}
+		after, err := marshalValues(e.After)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			tuples += ","
+		}
+		tuples += "(" + placeholders(8) + ")"
+		args = append(args, e.Time.UTC(), e.RequestID, e.Principal, e.Action,
+			e.Table, e.Key, before, after,
+		)
+	}
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (time, request_id, principal, action, table_name, row_key, before_values, after_values)
+		VALUES %s;`, s.table, tuples), args...,
+	)
+	return err
+}
+
+func marshalValues(values map[string]any) (any, error) {
+	if values == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(values)
+	return string(data), err
+}
+
+func (s *TableAuditSink) Read(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	conditions := []condition{}
+	for column, value := range map[string]string{
+		"table_name": filter.Table,
+		"row_key":    filter.Key,
+		"principal":  filter.Principal,
+		"action":     filter.Action,
+		"request_id": filter.RequestID,
+	} {
+		if value != "" {
+			conditions = append(conditions, condition{SQL: column + " = ?", Args: []any{value}})
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, condition{SQL: "time >= ?", Args: []any{filter.Since.UTC()}})
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, condition{SQL: "time < ?", Args: []any{filter.Until.UTC()}})
+	}
+	where, args := whereClause(conditions)
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT time, request_id, principal, action, table_name, row_key, before_values, after_values
+		FROM %s%s ORDER BY id %s LIMIT ? OFFSET ?;`, s.table, where, order),
+		append(args, filter.Limit, filter.Offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var timestamp string
+		var before, after sql.NullString
+		err := rows.Scan(&timestamp, &e.RequestID, &e.Principal, &e.Action, &e.Table, &e.Key, &before, &after)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, timestamp); err == nil {
+				e.Time = t
+				break
+			}
+		}
+		if before.Valid {
+			json.Unmarshal([]byte(before.String), &e.Before)
+		}
+		if after.Valid {
+			json.Unmarshal([]byte(after.String), &e.After)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, rows.Close()
+}
+
+// requestID takes X-Request-ID from the client or makes one up
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 {
+		return id
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// auditLog collects the entries of one request
+type auditLog struct {
+	entries []AuditEntry
+}
+
+func (a *auditLog) add(action string, table table, key any, before, after map[string]any) {
+	if a == nil {
+		return
+	}
+	a.entries = append(a.entries, AuditEntry{
+		Action: action,
+		Table:  table.Name,
+		Key:    scalarString(key),
+		Before: before,
+		After:  after,
+	})
+}
+
+// capture reads the row after the write and adds an entry for it,
+// skipping updates that didn't change anything
+func (a *auditLog) capture(q querier, action string, table table, key any, before map[string]any) error {
+	if a == nil {
+		return nil
+	}
+	after, err := snapshot(q, table, key)
+	if err != nil {
+		return err
+	}
+	if action == AuditUpdate && jsonEqual(before, after) {
+		return nil
+	}
+	a.add(action, table, key, before, after)
+	return nil
+}
+
+// snapshot reads a row for the audit log, nil when it doesn't exist
+func snapshot(q querier, table table, key any) (map[string]any, error) {
+	record, err := selectRecord(q, table, key, nil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return record, err
+}
+
+// newAuditLog returns nil when auditing is off, callers skip the
+// extra before and after reads in that case
+func (h *handler) newAuditLog() *auditLog {
+	if h.audit == nil {
+		return nil
+	}
+	return &auditLog{}
+}
+
+// commit commits tx together with the request's audit entries. A
+// TxAuditSink writes them inside tx, so a failure fails the request rather
+// than losing them; other sinks get them after the commit, when the change
+// can't be undone anymore, and their failures can only be logged.
+func (h *handler) commit(r *http.Request, tx *sql.Tx, log *auditLog) error {
+	if log == nil || len(log.entries) == 0 {
+		return tx.Commit()
+	}
+
+	principal := ""
+	if p, ok := PrincipalFromContext(r.Context()); ok {
+		principal = p.Subject
+	}
+	id, _ := r.Context().Value(REQUESTID).(string)
+	now := time.Now()
+	for i := range log.entries {
+		log.entries[i].Time = now
+		log.entries[i].RequestID = id
+		log.entries[i].Principal = principal
+	}
+
+	if sink, ok := h.audit.(TxAuditSink); ok {
+		if err := sink.WriteTx(r.Context(), tx, log.entries); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := h.audit.Write(r.Context(), log.entries); err != nil {
+		h.logger.Error("audit write failed", "request_id", id, "entries", len(log.entries), "error", err)
+	}
+	return nil
+}
+
+func (h *handler) readAudit(w http.ResponseWriter, r *http.Request) {
+	reader, ok := h.audit.(AuditReader)
+	if !ok {
+		http.Error(w, `{"error": "audit log is not readable"}`, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Table:     query.Get("table"),
+		Key:       query.Get("key"),
+		Principal: query.Get("principal"),
+		Action:    query.Get("action"),
+		RequestID: query.Get("request_id"),
+		Limit:     defaultAuditLimit,
+	}
+
+	var err error
+	for name, field := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			if *field, err = time.Parse(time.RFC3339, value); err != nil {
+				badRequest(w, fmt.Errorf("%s must be an RFC 3339 time", name))
+				return
+			}
+		}
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = min(limit, maxAuditLimit)
+	}
+	if offset, err := strconv.Atoi(query.Get("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	entries, err := reader.Read(r.Context(), filter)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{"entries": entries},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package dbexplorer
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject string         `json:"subject"`
+	Roles   []string       `json:"roles"`
+	Claims  map[string]any `json:"claims,omitempty"`
+}
+
+// Authenticator identifies the caller of a request. It returns a nil
+// principal and nil error when the request carries no credentials it
+// understands, so the next authenticator can try, and an error when the
+// credentials are present but invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// PrincipalFromContext returns the principal stored by the authentication
+// middleware, for use in middleware and handlers wrapping the explorer
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(PRINCIPAL).(*Principal)
+	return p, ok
+}
+
+// withAuthentication asks the authenticators in order and rejects the
+// request with 401 unless one of them recognizes the caller
+func (h *handler) withAuthentication(handler http.Handler) http.Handler {
+	if len(h.authenticators) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, auth := range h.authenticators {
+			principal, err := auth.Authenticate(r)
+			if err != nil {
+				h.logger.Info("authentication failed", "path", r.URL.Path, "error", err)
+				unauthorized(w)
+				return
+			}
+			if principal != nil {
+				ctx := context.WithValue(r.Context(), PRINCIPAL, principal)
+				handler.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+		unauthorized(w)
+	})
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, `{"error": "unauthorized"}`, http.StatusUnauthorized)
+}
+
+// bearerToken extracts the credentials of "Authorization: <scheme> <token>"
+func bearerToken(r *http.Request, scheme string) (string, bool) {
+	prefix, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(prefix, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+type apiKeyAuthenticator struct {
+	keys map[string]Principal
+}
+
+// NewAPIKeyAuthenticator accepts static keys sent as "X-API-Key: <key>"
+// or "Authorization: ApiKey <key>"
+func NewAPIKeyAuthenticator(keys map[string]Principal) Authenticator {
+	return apiKeyAuthenticator{keys: keys}
+}
+
+func (a apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		var ok bool
+		if key, ok = bearerToken(r, "ApiKey"); !ok {
+			return nil, nil
+		}
+	}
+
+	// compare against every key so the timing doesn't tell
+	// how many keys share a prefix with the one sent
+	var found *Principal
+	for candidate, principal := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			found = &principal
+		}
+	}
+	if found == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return found, nil
+}
@@ -0,0 +1,233 @@
+package dbexplorer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type batchOperation struct {
+	Op    string         `json:"op"`
+	Table string         `json:"table"`
+	ID    any            `json:"id"`
+	Body  map[string]any `json:"body"`
+}
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+func ErrBatchOperation(i int, err error) error {
+	return fmt.Errorf("operation %d: %w", i, err)
+}
+
+// resolveRef replaces {"$ref": N} with the key produced by operation N,
+// so an order line can point at the order created earlier in the batch
+func resolveRef(val any, keys []any) (any, error) {
+	ref, ok := val.(map[string]any)
+	if !ok {
+		return val, nil
+	}
+
+	index, ok := ref["$ref"].(float64)
+	if !ok || len(ref) != 1 {
+		return val, nil
+	}
+
+	i := int(index)
+	if float64(i) != index || i < 0 || i >= len(keys) || keys[i] == nil {
+		return nil, fmt.Errorf("invalid reference to operation %v", index)
+	}
+
+	// generated keys are int64, pass them on as JSON numbers
+	// so validateColumnType treats them like any other body value
+	if key, ok := keys[i].(int64); ok {
+		return float64(key), nil
+	}
+	return keys[i], nil
+}
+
+func (h *handler) runBatch(w http.ResponseWriter, r *http.Request) {
+	if h.rejectWrite(w, r) {
+		return
+	}
+
+	var request batchRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	opts := h.writeOptions(r)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	audit := h.newAuditLog()
+	keys := make([]any, 0, len(request.Operations))
+	results := make([]map[string]any, 0, len(request.Operations))
+	for i, op := range request.Operations {
+		table, ok := h.tables[op.Table]
+		if !ok {
+			badRequest(w, ErrBatchOperation(i, fmt.Errorf("unknown table %s", op.Table)))
+			return
+		}
+		if table.IsView {
+			badRequest(w, ErrBatchOperation(i, fmt.Errorf("view %s is read-only", op.Table)))
+			return
+		}
+		if batchOp := Operation(op.Op); !h.allowed(r, op.Table, batchOp) {
+			forbidden(w, ErrBatchOperation(i, ErrForbidden(batchOp, op.Table)))
+			return
+		}
+
+		for name, val := range op.Body {
+			op.Body[name], err = resolveRef(val, keys)
+			if err != nil {
+				badRequest(w, ErrBatchOperation(i, err))
+				return
+			}
+		}
+		op.ID, err = resolveRef(op.ID, keys)
+		if err != nil {
+			badRequest(w, ErrBatchOperation(i, err))
+			return
+		}
+
+		var query string
+		var args []any
+		switch op.Op {
+		case "create":
+			var values []any
+			var tuple string
+			values, err = insertValues(table, op.Body, nil, opts)
+			tuple, args = valuesTuple(values)
+			query = fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;",
+				table.Name, strings.Join(insertColumns(table), ","), tuple,
+			)
+		case "update":
+			var columnToUpdate []string
+			columnToUpdate, args, err = updateAssignments(table, op.Body, opts)
+			where, whereArgs := whereClause(
+				append(h.scopeConditions(r, table), primaryKeyCondition(table, op.ID)),
+			)
+			args = append(args, whereArgs...)
+			query = fmt.Sprintf("UPDATE %s SET %s%s;",
+				table.Name, strings.Join(columnToUpdate, ","), where,
+			)
+		case "delete":
+			conditions := append(h.rowSecurityConditions(r, table), primaryKeyCondition(table, op.ID))
+			query = fmt.Sprintf("DELETE FROM %s", table.Name)
+			if col, ok := table.softDeleteColumn(); ok {
+				query = fmt.Sprintf("UPDATE %s SET %s = %s", table.Name, col.Name, softDeleteValue(col))
+				conditions = append(conditions, activeCondition(col))
+			}
+			where, whereArgs := whereClause(conditions)
+			query += where + ";"
+			args = whereArgs
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err == nil && op.Op != "create" && op.ID == nil {
+			err = fmt.Errorf("id is required")
+		}
+		if err != nil {
+			badRequest(w, ErrBatchOperation(i, err))
+			return
+		}
+
+		var before map[string]any
+		if op.Op != "create" && audit != nil {
+			if before, err = snapshot(tx, table, op.ID); err != nil {
+				internalError(w, ErrBatchOperation(i, err))
+				return
+			}
+		}
+
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			internalError(w, ErrBatchOperation(i, err))
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			internalError(w, ErrBatchOperation(i, err))
+			return
+		}
+
+		switch op.Op {
+		case "create":
+			key, ok := op.Body[table.PrimaryKeyName]
+			if col := primaryKeyColumn(table); !ok || col.IsAutoIncrement {
+				key, err = result.LastInsertId()
+				if err != nil {
+					internalError(w, ErrBatchOperation(i, err))
+					return
+				}
+			}
+			if !h.batchRowVisible(w, r, tx, table, key, i, OpCreate) {
+				return
+			}
+			err = audit.capture(tx, AuditCreate, table, key, nil)
+			keys = append(keys, key)
+			results = append(results, map[string]any{table.PrimaryKeyName: key})
+		case "update":
+			if !h.batchRowVisible(w, r, tx, table, op.ID, i, OpUpdate) {
+				return
+			}
+			if rowsAffected > 0 {
+				err = audit.capture(tx, AuditUpdate, table, op.ID, before)
+			}
+			keys = append(keys, op.ID)
+			results = append(results, map[string]any{"updated": rowsAffected})
+		case "delete":
+			if rowsAffected > 0 {
+				err = audit.capture(tx, AuditDelete, table, op.ID, before)
+			}
+			keys = append(keys, op.ID)
+			results = append(results, map[string]any{"deleted": rowsAffected})
+		}
+		if err != nil {
+			internalError(w, ErrBatchOperation(i, err))
+			return
+		}
+	}
+
+	if err := h.commit(r, tx, audit); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{"results": results},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
+
+// batchRowVisible answers 403 when operation i wrote a row out of the
+// caller's reach, runBatch then returns and the transaction is rolled back
+func (h *handler) batchRowVisible(w http.ResponseWriter, r *http.Request, tx *sql.Tx, table table, key any, i int, op Operation) bool {
+	visible, err := h.rowsVisible(tx, r, table, []any{key})
+	if err != nil {
+		internalError(w, ErrBatchOperation(i, err))
+		return false
+	}
+	if !visible {
+		forbidden(w, ErrBatchOperation(i, ErrForbidden(op, table.Name)))
+		return false
+	}
+	return true
+}
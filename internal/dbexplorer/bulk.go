@@ -0,0 +1,267 @@
+package dbexplorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const insertBatchSize = 500
+
+// decodeRows accepts a single JSON object, a JSON array of objects
+// or an NDJSON stream; isBulk reports whether more than one row may follow
+func decodeRows(r *http.Request) (rows []map[string]any, isBulk bool, err error) {
+	decoder := json.NewDecoder(r.Body)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-ndjson" {
+		for {
+			var row map[string]any
+			err := decoder.Decode(&row)
+			if errors.Is(err, io.EOF) {
+				return rows, true, nil
+			}
+			if err != nil {
+				return nil, true, err
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, false, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimLeft(raw, " \t\r\n"), []byte("[")) {
+		err := json.Unmarshal(raw, &rows)
+		return rows, true, err
+	}
+
+	var row map[string]any
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return nil, false, err
+	}
+	return []map[string]any{row}, false, nil
+}
+
+func insertColumns(table table) []string {
+	var columnNames []string
+	for _, col := range table.Columns {
+		if col.IsAutoIncrement {
+			continue
+		}
+		columnNames = append(columnNames, col.Name)
+	}
+	return columnNames
+}
+
+// insertValues validates a request body against the columns
+// returned by insertColumns, in the same order
+func insertValues(table table, requestBody map[string]any, parent *parentKey, opts writeOptions) ([]any, error) {
+	if opts.Strict {
+		if err := checkUnknownFields(table, requestBody); err != nil {
+			return nil, err
+		}
+	}
+
+	fixed, err := checkFixedValues(table, requestBody, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []any
+	for _, col := range table.Columns {
+		if col.IsAutoIncrement {
+			continue
+		}
+
+		if val, ok := fixed[col.Name]; ok {
+			values = append(values, val)
+			continue
+		}
+
+		if parent != nil && col.Name == parent.Column {
+			values = append(values, parent.Value)
+			continue
+		}
+
+		val, ok := requestBody[col.Name]
+		if !ok || !col.writable() {
+			val, err := omittedValue(col, opts)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+			continue
+		}
+
+		val, err := validateColumnType(col, val)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// valuesTuple renders "(?,DEFAULT,?)" for a row from insertValues,
+// leaving sqlDefault markers out of the returned args
+func valuesTuple(values []any) (string, []any) {
+	tuple := make([]string, 0, len(values))
+	args := make([]any, 0, len(values))
+	for _, val := range values {
+		if _, ok := val.(sqlDefault); ok {
+			tuple = append(tuple, "DEFAULT")
+			continue
+		}
+		tuple = append(tuple, "?")
+		args = append(args, val)
+	}
+	return "(" + strings.Join(tuple, ",") + ")", args
+}
+
+func (h *handler) createRows(w http.ResponseWriter, r *http.Request, table table, requestRows []map[string]any, parent *parentKey) {
+	opts := h.writeOptions(r)
+	rowsValues := make([][]any, 0, len(requestRows))
+	rowErrors := []map[string]any{}
+	for i, requestBody := range requestRows {
+		values, err := insertValues(table, requestBody, parent, opts)
+		if err != nil {
+			rowErrors = append(rowErrors, map[string]any{"row": i, "error": err.Error()})
+			continue
+		}
+		rowsValues = append(rowsValues, values)
+	}
+
+	if len(rowErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		err := json.NewEncoder(w).Encode(
+			map[string]any{"error": "invalid rows", "rows": rowErrors},
+		)
+		if err != nil {
+			internalError(w, err)
+		}
+		return
+	}
+
+	columnNames := insertColumns(table)
+	isAutoIncrement := primaryKeyColumn(table).IsAutoIncrement
+	primaryKey := -1
+	for i, name := range columnNames {
+		if name == table.PrimaryKeyName {
+			primaryKey = i
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// ids are spaced by auto_increment_increment, which isn't 1
+	// on multi-primary setups
+	step := int64(1)
+	if isAutoIncrement {
+		if err := tx.QueryRow("SELECT @@auto_increment_increment;").Scan(&step); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	keys := make([]any, 0, len(rowsValues))
+	for start := 0; start < len(rowsValues); start += insertBatchSize {
+		batch := rowsValues[start:min(start+insertBatchSize, len(rowsValues))]
+
+		tuples := make([]string, 0, len(batch))
+		args := make([]any, 0, len(batch)*len(columnNames))
+		for _, values := range batch {
+			tuple, tupleArgs := valuesTuple(values)
+			tuples = append(tuples, tuple)
+			args = append(args, tupleArgs...)
+		}
+
+		result, err := tx.Exec(
+			fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;",
+				table.Name, strings.Join(columnNames, ","), strings.Join(tuples, ","),
+			), args...,
+		)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		// a multi-row INSERT reports the first generated id,
+		// the rest of the batch follows it step by step
+		firstID, err := result.LastInsertId()
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		for i, values := range batch {
+			switch {
+			case isAutoIncrement:
+				keys = append(keys, firstID+int64(i)*step)
+			case primaryKey >= 0:
+				if _, ok := values[primaryKey].(sqlDefault); ok {
+					keys = append(keys, nil)
+					continue
+				}
+				keys = append(keys, values[primaryKey])
+			}
+		}
+	}
+
+	visible, err := h.rowsVisible(tx, r, table, keys)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if !visible {
+		forbidden(w, ErrForbidden(OpCreate, table.Name))
+		return
+	}
+
+	audit := h.newAuditLog()
+	for _, key := range keys {
+		if key == nil {
+			continue
+		}
+		if err := audit.capture(tx, AuditCreate, table, key, nil); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if err := h.commit(r, tx, audit); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if wantsRepresentation(r) {
+		h.writeRepresentation(w, r, table, keys, true)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{table.PrimaryKeyName: keys},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
@@ -0,0 +1,105 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var lastModifiedColumns = []string{"updated_at", "modified_at", "last_modified"}
+
+var timestampLayouts = []string{
+	time.DateTime,
+	time.RFC3339Nano,
+	time.DateOnly,
+}
+
+func isLastModifiedColumn(name, sqlType string) bool {
+	sqlType = strings.ToUpper(sqlType)
+	if !strings.Contains(sqlType, "DATE") && !strings.Contains(sqlType, "TIME") {
+		return false
+	}
+
+	for _, candidate := range lastModifiedColumns {
+		if strings.EqualFold(name, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastModified returns the newest value of the table's updated_at-style
+// column among records, or zero time if there is none. It only stands for
+// single records: a collection changes without it moving.
+func lastModified(table table, records []map[string]any) time.Time {
+	var latest time.Time
+	if table.LastModified == "" {
+		return latest
+	}
+
+	for _, record := range records {
+		value, ok := record[table.LastModified].(string)
+		if !ok {
+			continue
+		}
+
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				if t.After(latest) {
+					latest = t
+				}
+				break
+			}
+		}
+	}
+	return latest
+}
+
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+
+	if modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// writeCacheable serves a read with validators and answers 304 when the
+// client's copy is still current; an empty etag is derived from the body
+func (h *handler) writeCacheable(w http.ResponseWriter, r *http.Request, response Response, etag string, modified time.Time) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if etag == "" {
+		etag = hashETag(body)
+	}
+
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+	// row filters, masks and policies make the body depend on the caller
+	if len(h.authenticators) > 0 {
+		w.Header().Add("Vary", "Authorization, X-API-Key")
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write(append(body, '\n'))
+}
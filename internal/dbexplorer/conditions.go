@@ -0,0 +1,30 @@
+package dbexplorer
+
+import "strings"
+
+type condition struct {
+	SQL  string
+	Args []any
+}
+
+func primaryKeyCondition(table table, rowID any) condition {
+	return condition{
+		SQL:  table.PrimaryKeyName + " = ?",
+		Args: []any{rowID},
+	}
+}
+
+func whereClause(conditions []condition) (string, []any) {
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	parts := make([]string, 0, len(conditions))
+	args := []any{}
+	for _, c := range conditions {
+		parts = append(parts, "("+c.SQL+")")
+		args = append(args, c.Args...)
+	}
+
+	return " WHERE " + strings.Join(parts, " AND "), args
+}
@@ -3,19 +3,66 @@ package dbexplorer
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 )
 
 type handler struct {
-	db     *sql.DB
-	tables map[string]table
+	db       *sql.DB
+	replica  *sql.DB
+	tables   map[string]table
+	routines map[string]*routine
+
+	maxAffectedRows int
+	cacheControl    string
+	strictFields    bool
+	requireFields   bool
+
+	softDeleteColumns map[string]string
+	versionColumns    map[string]string
+	rowFilters        map[string]string
+
+	includeTables []string
+	excludeTables []string
+	columnRules   []columnRule
+	maskRules     []maskRule
+
+	readOnly    bool
+	maintenance *maintenance
+	// routes is the API's mux, consulted for the Allow header
+	routes      *http.ServeMux
+	maxPageSize int
+	pathPrefix  string
+	logger      *slog.Logger
+	middleware  []func(http.Handler) http.Handler
+
+	authenticators []Authenticator
+	policies       []Policy
+	adminRole      string
+
+	audit AuditSink
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
 type table struct {
 	Name           string
 	PrimaryKeyName string
 	Columns        []column
+	Relations      map[string]relation
+	IsView         bool
+	LastModified   string
+	SoftDelete     string
+	VersionColumn  string
+	RowFilter      *rowFilter
+	Masks          map[string]maskRule
 }
 
 type column struct {
@@ -24,6 +71,7 @@ type column struct {
 	IsNullable      bool
 	IsAutoIncrement bool
 	DefaultValue    sql.NullString
+	Access          columnAccess
 }
 
 type columnType int
@@ -35,60 +83,155 @@ const (
 	TYPEBOOL
 )
 
-func NewDBExplorer(db *sql.DB) (http.Handler, error) {
+func NewDBExplorer(db *sql.DB, opts ...Option) (*Explorer, error) {
 	h := newHandler(db)
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	// the audit table is written by the sink only
+	if sink, ok := h.audit.(interface{ TableName() string }); ok {
+		h.excludeTables = append(h.excludeTables, sink.TableName())
+	}
+
 	err := h.registerTablesAndColumns()
 	if err != nil {
-		return http.NotFoundHandler(), err
+		return nil, err
 	}
+	err = h.registerRoutines()
+	if err != nil {
+		return nil, err
+	}
+	err = h.checkPolicies()
+	if err != nil {
+		return nil, err
+	}
+	h.logger.Info("schema loaded", "tables", len(h.tables), "routines", len(h.routines))
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", h.readAllTables)
 	mux.Handle(
 		"GET /{table}",
-		h.withTableAccess(http.HandlerFunc(h.readTable)),
+		h.withTableAccess(h.withPermission(OpRead, http.HandlerFunc(h.readTable))),
 	)
 	mux.Handle(
 		"GET /{table}/{rowID}",
-		h.withTableAccess(h.withRowAccess(http.HandlerFunc(h.readRow))),
+		h.withTableAccess(h.withPermission(OpRead, h.withAsOf(h.withRowAccess(http.HandlerFunc(h.readRow))))),
+	)
+	mux.Handle(
+		"POST /{table}",
+		h.withTableAccess(h.withPermission(OpUpdate, h.withWriteAccess(http.HandlerFunc(h.updateRows)))),
+	)
+	mux.Handle(
+		"DELETE /{table}",
+		h.withTableAccess(h.withPermission(OpDelete, h.withWriteAccess(http.HandlerFunc(h.deleteRows)))),
 	)
 	mux.Handle(
 		"PUT /{table}/",
-		h.withTableAccess(http.HandlerFunc(h.createRow)),
+		h.withTableAccess(h.withPermission(OpCreate, h.withWriteAccess(http.HandlerFunc(h.createRow)))),
 	)
 	mux.Handle(
 		"POST /{table}/{rowID}",
-		h.withTableAccess(h.withRowAccess(http.HandlerFunc(h.updateRow))),
+		h.withTableAccess(h.withPermission(OpUpdate, h.withWriteAccess(h.withRowAccess(http.HandlerFunc(h.updateRow))))),
+	)
+	mux.Handle(
+		"GET /{table}/{rowID}/{childTable}",
+		h.withTableAccess(h.withPermission(OpRead, h.withRowAccess(h.withChildAccess(
+			h.withPermission(OpRead, http.HandlerFunc(h.readTable)),
+		)))),
+	)
+	mux.Handle(
+		"PUT /{table}/{rowID}/{childTable}/",
+		h.withTableAccess(h.withPermission(OpRead, h.withRowAccess(h.withChildAccess(
+			h.withPermission(OpCreate, h.withWriteAccess(http.HandlerFunc(h.createRow))),
+		)))),
+	)
+	mux.HandleFunc("POST /_rpc/{procedure}", h.callRoutine)
+	mux.Handle(
+		"POST /{table}/{rowID}/_restore",
+		h.withTableAccess(h.withAdmin(h.withWriteAccess(http.HandlerFunc(h.restoreRow)))),
+	)
+	mux.HandleFunc("POST /_batch", h.runBatch)
+	mux.Handle("GET /_maintenance", h.withAdmin(http.HandlerFunc(h.maintenanceMode)))
+	mux.Handle("PUT /_maintenance", h.withAdmin(http.HandlerFunc(h.maintenanceMode)))
+	if h.audit != nil {
+		mux.Handle("GET /_audit", h.withAdminRole(http.HandlerFunc(h.readAudit)))
+	}
+	if _, ok := h.audit.(AuditReader); ok {
+		mux.Handle(
+			"GET /{table}/{rowID}/_history",
+			h.withTableAccess(h.withPermission(OpRead, h.withAuditedRow(http.HandlerFunc(h.rowHistory)))),
+		)
+		mux.Handle(
+			"POST /{table}/{rowID}/_revert",
+			h.withTableAccess(h.withPermission(OpUpdate, h.withWriteAccess(h.withAuditedRow(http.HandlerFunc(h.revertRow))))),
+		)
+	}
+	mux.Handle(
+		"PATCH /{table}/{rowID}",
+		h.withTableAccess(h.withPermission(OpUpdate, h.withWriteAccess(h.withRowAccess(http.HandlerFunc(h.patchRow))))),
+	)
+	mux.Handle(
+		"PUT /{table}/{rowID}",
+		h.withTableAccess(h.withPermission(OpUpdate, h.withWriteAccess(h.withRowAccess(http.HandlerFunc(h.replaceRow))))),
 	)
 	mux.Handle(
 		"DELETE /{table}/{rowID}",
-		h.withTableAccess(http.HandlerFunc(h.deleteRow)),
+		h.withTableAccess(h.withPermission(OpDelete, h.withWriteAccess(http.HandlerFunc(h.deleteRow)))),
 	)
 
-	return mux, nil
+	h.routes = mux
+
+	var routes http.Handler = mux
+	for _, mw := range slices.Backward(h.middleware) {
+		routes = mw(routes)
+	}
+	routes = h.withRequestLog(h.withAuthentication(routes))
+
+	return &Explorer{h: &h, routes: routes}, nil
 }
 
 func newHandler(db *sql.DB) handler {
 	return handler{
-		db:     db,
-		tables: map[string]table{},
+		db:       db,
+		tables:   map[string]table{},
+		routines: map[string]*routine{},
+
+		maxAffectedRows: defaultMaxAffectedRows,
+		logger:          slog.New(slog.DiscardHandler),
+		adminRole:       defaultAdminRole,
+		maintenance:     &maintenance{},
+
+		softDeleteColumns: map[string]string{},
+		versionColumns:    map[string]string{},
+		rowFilters:        map[string]string{},
 	}
 }
 
 func (h *handler) registerTablesAndColumns() error {
-	tables, err := h.db.Query(`SHOW TABLES;`)
+	tables, err := h.db.Query(`SHOW FULL TABLES;`)
 	if err != nil {
 		return err
 	}
 
 	tableNames := []string{}
+	isView := map[string]bool{}
 	for tables.Next() {
-		var tableName string
-		if err := tables.Scan(&tableName); err != nil {
+		var tableName, tableType string
+		if err := tables.Scan(&tableName, &tableType); err != nil {
+			tables.Close()
+			return err
+		}
+		expose, err := h.exposeTable(tableName)
+		if err != nil {
 			tables.Close()
 			return err
 		}
+		if !expose {
+			continue
+		}
 		tableNames = append(tableNames, tableName)
+		isView[tableName] = tableType == "VIEW"
 	}
 
 	if err := tables.Err(); err != nil {
@@ -109,7 +252,7 @@ func (h *handler) registerTablesAndColumns() error {
 		}
 
 		columns := []column{}
-		var primaryKeyName string
+		var primaryKeyName, lastModifiedName string
 		for tableColumns.Next() {
 			var c column
 			var cType, cNullable, cKey, cExtra string
@@ -131,6 +274,10 @@ func (h *handler) registerTablesAndColumns() error {
 				primaryKeyName = c.Name
 			}
 
+			if isLastModifiedColumn(c.Name, cType) {
+				lastModifiedName = c.Name
+			}
+
 			if cExtra == "auto_increment" {
 				c.IsAutoIncrement = true
 			}
@@ -147,16 +294,85 @@ func (h *handler) registerTablesAndColumns() error {
 			return err
 		}
 
-		h.tables[tableName] = table{
+		t := table{
 			Name:           tableName,
 			PrimaryKeyName: primaryKeyName,
+			LastModified:   lastModifiedName,
 			Columns:        columns,
+			Relations:      map[string]relation{},
+			IsView:         isView[tableName],
+			Masks:          map[string]maskRule{},
+		}
+		if err := h.applyColumnRules(&t); err != nil {
+			return err
 		}
+		if err := h.registerMasks(&t); err != nil {
+			return err
+		}
+		if _, ok := readableColumn(t, t.LastModified); !ok {
+			t.LastModified = ""
+		}
+		h.tables[tableName] = t
 	}
 
+	err = h.registerColumnOptions(h.softDeleteColumns, func(t *table, colName string) {
+		t.SoftDelete = colName
+	})
+	if err != nil {
+		return err
+	}
+
+	err = h.registerColumnOptions(h.versionColumns, func(t *table, colName string) {
+		t.VersionColumn = colName
+	})
+	if err != nil {
+		return err
+	}
+
+	for tableName, template := range h.rowFilters {
+		t, ok := h.tables[tableName]
+		if !ok {
+			continue
+		}
+		if t.RowFilter, err = compileRowFilter(t, template); err != nil {
+			return err
+		}
+		h.tables[tableName] = t
+	}
+
+	return h.registerRelations()
+}
+
+func ErrUnknownColumn(tableName, colName string) error {
+	return fmt.Errorf("column %s not found in table %s", colName, tableName)
+}
+
+// registerColumnOptions attaches per-table columns configured through
+// options (soft delete, version) once the schema is known
+func (h *handler) registerColumnOptions(columns map[string]string, apply func(t *table, colName string)) error {
+	for tableName, colName := range columns {
+		t, ok := h.tables[tableName]
+		if !ok {
+			continue
+		}
+		if !hasColumn(t, colName) {
+			return ErrUnknownColumn(tableName, colName)
+		}
+		apply(&t, colName)
+		h.tables[tableName] = t
+	}
 	return nil
 }
 
+func primaryKeyColumn(t table) column {
+	for _, col := range t.Columns {
+		if col.Name == t.PrimaryKeyName {
+			return col
+		}
+	}
+	return column{}
+}
+
 func getType(sqlType string) columnType {
 	sqlType = strings.ToUpper(sqlType)
 
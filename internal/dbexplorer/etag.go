@@ -0,0 +1,98 @@
+package dbexplorer
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// recordETag is the value of the table's version column when one is
+// configured and readable, otherwise a hash of the record as it is served; json.Marshal
+// sorts map keys so equal records always produce the same tag
+func recordETag(table table, record map[string]any) string {
+	if version, ok := record[table.VersionColumn]; ok {
+		return strconv.Quote(fmt.Sprint(version))
+	}
+
+	data, _ := json.Marshal(record)
+	return hashETag(data)
+}
+
+func hashETag(data []byte) string {
+	sum := sha1.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// beginRowWrite opens a transaction holding a lock on the row when the
+// request carries If-Match, is audited or row filtered, or the caller asks
+// to lock, so the compare, the before snapshot and the rowsVisible check
+// happen atomically with the write. It returns a nil tx when none applies,
+// the row as it was before the write (nil if it doesn't exist) and false
+// when an error has already been written.
+func (h *handler) beginRowWrite(w http.ResponseWriter, r *http.Request, table table, rowID any, audit *auditLog, lock bool) (*sql.Tx, map[string]any, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" && audit == nil && len(h.rowSecurityConditions(r, table)) == 0 && !lock {
+		return nil, nil, true
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return nil, nil, false
+	}
+
+	where, args := whereClause(
+		append(h.rowSecurityConditions(r, table), primaryKeyCondition(table, rowID)),
+	)
+	row := tx.QueryRow(
+		fmt.Sprintf("SELECT * FROM %s%s FOR UPDATE;", table.Name, where), args...,
+	)
+
+	values := scanValues(table)
+	err = row.Scan(values...)
+	if err == sql.ErrNoRows && ifMatch == "" {
+		return tx, nil, true
+	}
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		http.Error(w, `{"error": "precondition failed"}`, http.StatusPreconditionFailed)
+		return nil, nil, false
+	}
+	if err != nil {
+		tx.Rollback()
+		internalError(w, err)
+		return nil, nil, false
+	}
+
+	record := makeRecord(table, values)
+	if ifMatch == "" {
+		return tx, record, true
+	}
+
+	// the client got its tag from the record as served to it
+	served := maps.Clone(record)
+	h.maskRecords(r, table, []map[string]any{served})
+	if !etagMatches(ifMatch, recordETag(table, served)) {
+		tx.Rollback()
+		http.Error(w, `{"error": "precondition failed"}`, http.StatusPreconditionFailed)
+		return nil, nil, false
+	}
+
+	return tx, record, true
+}
@@ -0,0 +1,165 @@
+package dbexplorer
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Explorer is the http.Handler returned by NewDBExplorer; besides serving
+// the API it describes the loaded schema for services embedding it
+type Explorer struct {
+	h      *handler
+	routes http.Handler
+}
+
+// TableInfo describes a table or view exposed by the explorer
+type TableInfo struct {
+	Name       string
+	PrimaryKey string
+	IsView     bool
+	Columns    []ColumnInfo
+	Relations  []string
+}
+
+type ColumnInfo struct {
+	Name          string
+	Type          string
+	Nullable      bool
+	AutoIncrement bool
+	ReadOnly      bool
+	WriteOnly     bool
+	Default       *string
+}
+
+func (t columnType) String() string {
+	switch t {
+	case TYPEINT:
+		return "int"
+	case TYPEFLOAT:
+		return "float"
+	case TYPEBOOL:
+		return "bool"
+	}
+	return "string"
+}
+
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e.h.pathPrefix == "" {
+		e.routes.ServeHTTP(w, r)
+		return
+	}
+	http.StripPrefix(e.h.pathPrefix, e.routes).ServeHTTP(w, r)
+}
+
+// Mount registers the explorer on mux under prefix, e.g. "/db",
+// regardless of WithPathPrefix
+func (e *Explorer) Mount(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, e.routes))
+}
+
+// SetMaintenance pauses writes with reason, or resumes them when
+// enabled is false; the same switch is served on /_maintenance
+func (e *Explorer) SetMaintenance(enabled bool, reason string) {
+	e.h.maintenance.set(maintenanceStatus{Enabled: enabled, Reason: reason})
+}
+
+// Maintenance reports whether writes are paused and why
+func (e *Explorer) Maintenance() (enabled bool, reason string) {
+	status := e.h.maintenance.status()
+	return status.Enabled, status.Reason
+}
+
+// Tables lists the exposed tables and views sorted by name
+func (e *Explorer) Tables() []TableInfo {
+	infos := make([]TableInfo, 0, len(e.h.tables))
+	for name := range e.h.tables {
+		info, _ := e.Table(name)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func (e *Explorer) Table(name string) (TableInfo, bool) {
+	t, ok := e.h.tables[name]
+	if !ok {
+		return TableInfo{}, false
+	}
+
+	info := TableInfo{
+		Name:       t.Name,
+		PrimaryKey: t.PrimaryKeyName,
+		IsView:     t.IsView,
+		Columns:    make([]ColumnInfo, 0, len(t.Columns)),
+		Relations:  make([]string, 0, len(t.Relations)),
+	}
+	for _, col := range t.Columns {
+		if col.Access == accessHidden {
+			continue
+		}
+		colInfo := ColumnInfo{
+			Name:          col.Name,
+			Type:          col.Type.String(),
+			Nullable:      col.IsNullable,
+			AutoIncrement: col.IsAutoIncrement,
+			ReadOnly:      col.Access == accessReadOnly,
+			WriteOnly:     col.Access == accessWriteOnly,
+		}
+		if col.DefaultValue.Valid {
+			colInfo.Default = &col.DefaultValue.String
+		}
+		info.Columns = append(info.Columns, colInfo)
+	}
+	for name := range t.Relations {
+		info.Relations = append(info.Relations, name)
+	}
+	sort.Strings(info.Relations)
+	return info, true
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withRequestLog tags every request with an id, echoed in X-Request-ID,
+// and logs it, server errors at error level
+func (h *handler) withRequestLog(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), REQUESTID, id))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		h.logger.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"request_id", id,
+		)
+	})
+}
@@ -0,0 +1,210 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrFilterRequired = errors.New("filter is required")
+
+// filteredWriteParams are the query params of POST and DELETE /{table}
+// that aren't filters
+var filteredWriteParams = []string{"dry_run", "include_deleted"}
+
+func ErrUnknownParam(name string) error {
+	return fmt.Errorf("unknown parameter %s", name)
+}
+
+func ErrTooManyRows(count, limit int) error {
+	return fmt.Errorf("filter matches %d rows, limit is %d", count, limit)
+}
+
+func (h *handler) updateRows(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	table := h.tables[tableName]
+
+	conditions, err := h.requiredFilters(table, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	var requestBody map[string]any
+	err = json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	columnToUpdate, values, err := updateAssignments(table, requestBody, h.writeOptions(r))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	h.execFiltered(w, r, table, conditions, "updated",
+		fmt.Sprintf("UPDATE %s SET %s", tableName, strings.Join(columnToUpdate, ",")), values,
+	)
+}
+
+func (h *handler) deleteRows(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	table := h.tables[tableName]
+
+	conditions, err := h.requiredFilters(table, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	statement := fmt.Sprintf("DELETE FROM %s", tableName)
+	if col, ok := table.softDeleteColumn(); ok {
+		statement = fmt.Sprintf("UPDATE %s SET %s = %s", tableName, col.Name, softDeleteValue(col))
+	}
+
+	h.execFiltered(w, r, table, conditions, "deleted", statement, nil)
+}
+
+// requiredFilters refuses requests without filters, so a bare POST or
+// DELETE on a table can't touch every row, and with unknown params, so a
+// misspelled filter can't widen the match
+func (h *handler) requiredFilters(table table, r *http.Request) ([]condition, error) {
+	if err := h.checkMaskedFilters(r, table); err != nil {
+		return nil, err
+	}
+	conditions, err := parseFilters(table, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.URL.Query())) {
+		if _, ok := readableColumn(table, name); !ok && !slices.Contains(filteredWriteParams, name) {
+			return nil, ErrUnknownParam(name)
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, ErrFilterRequired
+	}
+	return append(conditions, h.scopeConditions(r, table)...), nil
+}
+
+// execFiltered counts the matching rows and runs the statement in one
+// transaction, stopping short of it for ?dry_run=true or when the count
+// exceeds maxAffectedRows
+func (h *handler) execFiltered(w http.ResponseWriter, r *http.Request, table table, conditions []condition, resultKey, statement string, args []any) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	where, whereArgs := whereClause(conditions)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// an audited or row filtered statement needs the rows themselves,
+	// not just their count
+	audit := h.newAuditLog()
+	rowFiltered := len(h.rowSecurityConditions(r, table)) > 0 && resultKey == "updated"
+	var before []map[string]any
+	var count int
+	if audit != nil || rowFiltered {
+		rows, err := tx.Query(
+			fmt.Sprintf("SELECT * FROM %s%s FOR UPDATE;", table.Name, where), whereArgs...,
+		)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if before, err = scanRecords(rows, table); err != nil {
+			internalError(w, err)
+			return
+		}
+		count = len(before)
+	} else {
+		err = tx.QueryRow(
+			fmt.Sprintf("SELECT COUNT(*) FROM %s%s FOR UPDATE;", table.Name, where), whereArgs...,
+		).Scan(&count)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if h.maxAffectedRows > 0 && count > h.maxAffectedRows {
+		badRequest(w, ErrTooManyRows(count, h.maxAffectedRows))
+		return
+	}
+
+	if dryRun {
+		err = json.NewEncoder(w).Encode(
+			Response{
+				map[string]any{resultKey: count, "dry_run": true},
+			},
+		)
+		if err != nil {
+			internalError(w, err)
+		}
+		return
+	}
+
+	result, err := tx.Exec(statement+where+";", append(args, whereArgs...)...)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if rowFiltered {
+		keys := make([]any, 0, len(before))
+		for _, record := range before {
+			keys = append(keys, record[table.PrimaryKeyName])
+		}
+		visible, err := h.rowsVisible(tx, r, table, keys)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if !visible {
+			forbidden(w, ErrForbidden(OpUpdate, table.Name))
+			return
+		}
+	}
+
+	action := AuditUpdate
+	if resultKey == "deleted" {
+		action = AuditDelete
+	}
+	for _, record := range before {
+		key := record[table.PrimaryKeyName]
+		if err := audit.capture(tx, action, table, key, record); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if err := h.commit(r, tx, audit); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{resultKey: rowsAffected},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
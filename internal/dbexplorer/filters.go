@@ -0,0 +1,105 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var filterOperators = map[string]string{
+	"eq":   "=",
+	"neq":  "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+}
+
+func ErrInvalidFilter(colName string) error {
+	return fmt.Errorf("invalid filter for field %s", colName)
+}
+
+func ErrUnfilterableField(colName string) error {
+	return fmt.Errorf("field %s can't be filtered", colName)
+}
+
+// parseFilters turns query params like ?status=eq.stale or ?updated=not.is.null
+// into conditions; a filter on a hidden or write-only column is refused
+// rather than dropped, which would widen the match, and params that don't
+// name a column are left to other handlers
+func parseFilters(table table, query url.Values) ([]condition, error) {
+	conditions := []condition{}
+	for _, col := range table.Columns {
+		if !col.readable() {
+			if query.Has(col.Name) {
+				return nil, ErrUnfilterableField(col.Name)
+			}
+			continue
+		}
+		for _, param := range query[col.Name] {
+			c, err := parseFilter(col, param)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, c)
+		}
+	}
+	return conditions, nil
+}
+
+func parseFilter(col column, param string) (condition, error) {
+	negate := false
+	if rest, ok := strings.CutPrefix(param, "not."); ok {
+		negate = true
+		param = rest
+	}
+
+	op, value, ok := strings.Cut(param, ".")
+	if !ok {
+		return condition{}, ErrInvalidFilter(col.Name)
+	}
+
+	var c condition
+	switch op {
+	case "is":
+		switch value {
+		case "null":
+			c.SQL = col.Name + " IS NULL"
+		case "true":
+			c.SQL = col.Name + " IS TRUE"
+		case "false":
+			c.SQL = col.Name + " IS FALSE"
+		default:
+			return condition{}, ErrInvalidFilter(col.Name)
+		}
+	case "in":
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "("), ")")
+		items := strings.Split(value, ",")
+		for _, item := range items {
+			c.Args = append(c.Args, filterValue(col, item))
+		}
+		c.SQL = fmt.Sprintf("%s IN (%s)",
+			col.Name, strings.TrimSuffix(strings.Repeat("?,", len(items)), ","),
+		)
+	default:
+		sqlOp, ok := filterOperators[op]
+		if !ok {
+			return condition{}, ErrInvalidFilter(col.Name)
+		}
+		c.SQL = fmt.Sprintf("%s %s ?", col.Name, sqlOp)
+		c.Args = []any{filterValue(col, value)}
+	}
+
+	if negate {
+		c.SQL = "NOT (" + c.SQL + ")"
+	}
+	return c, nil
+}
+
+func filterValue(col column, value string) any {
+	if col.Type == TYPEBOOL {
+		return value == "true" || value == "1"
+	}
+	return value
+}
@@ -1,34 +1,55 @@
 package dbexplorer
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Response struct {
 	Response map[string]any `json:"response"`
 }
 
+var ErrNothingToUpdate = errors.New("nothing to update")
+
 func ErrTypeMismatch(colName string) error {
 	return fmt.Errorf("field %s have invalid type", colName)
 }
 
+func ErrUnknownFields(colNames []string) error {
+	return fmt.Errorf("unknown fields: %s", strings.Join(colNames, ", "))
+}
+
 func (h *handler) readAllTables(w http.ResponseWriter, r *http.Request) {
 	tables := make([]string, 0, len(h.tables))
-	for table := range h.tables {
-		tables = append(tables, table)
+	views := []string{}
+	for name, table := range h.tables {
+		if !h.allowedAny(r, name) {
+			continue
+		}
+		if table.IsView {
+			views = append(views, name)
+			continue
+		}
+		tables = append(tables, name)
 	}
 
 	sort.Strings(tables)
+	sort.Strings(views)
+
+	response := map[string]any{"tables": tables}
+	if len(views) > 0 {
+		response["views"] = views
+	}
 
 	err := json.NewEncoder(w).Encode(
-		Response{
-			map[string]any{"tables": tables},
-		},
+		Response{response},
 	)
 	if err != nil {
 		internalError(w, err)
@@ -41,127 +62,166 @@ func (h *handler) readTable(w http.ResponseWriter, r *http.Request) {
 	offsetString := r.FormValue("offset")
 
 	limit, err := strconv.Atoi(limitString)
-	if err != nil {
+	if err != nil || limit < 0 {
 		limit = 5
 	}
+	if h.maxPageSize > 0 && limit > h.maxPageSize {
+		limit = h.maxPageSize
+	}
 	offset, err := strconv.Atoi(offsetString)
-	if err != nil {
+	if err != nil || offset < 0 {
 		offset = 0
 	}
 
 	tableName := r.Context().Value(TABLE).(string)
 	table := h.tables[tableName]
 
-	rows, err := h.db.Query(
-		fmt.Sprintf("SELECT * FROM %s;", tableName),
-	)
+	expand, err := expandNames(table, r.URL.Query()["expand"])
 	if err != nil {
-		internalError(w, err)
+		badRequest(w, err)
+		return
+	}
+	if !h.checkExpand(w, r, table, expand) {
 		return
 	}
 
-	defer rows.Close()
-
-	records := make([]map[string]any, 0, limit)
-	for i := 0; rows.Next(); i++ {
-		if i < offset {
-			continue
-		}
-
-		values := make([]any, len(table.Columns))
-		for i := range values {
-			values[i] = new([]byte)
-		}
-
-		err := rows.Scan(values...)
-		if err != nil {
-			internalError(w, err)
-			return
-		}
+	if err := h.checkMaskedFilters(r, table); err != nil {
+		badRequest(w, err)
+		return
+	}
+	conditions, err := parseFilters(table, r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	conditions = append(conditions, h.scopeConditions(r, table)...)
+	if parent, ok := r.Context().Value(PARENT).(parentKey); ok {
+		conditions = append(conditions, parent.condition())
+	}
 
-		record := make(map[string]any, len(table.Columns))
-		for i := range table.Columns {
-			raw := *values[i].(*[]byte)
-			record[table.Columns[i].Name] = convertValue(raw, table.Columns[i].Type)
-		}
+	where, args := whereClause(conditions)
+	orderBy := ""
+	if table.PrimaryKeyName != "" {
+		orderBy = " ORDER BY " + table.PrimaryKeyName
+	}
 
-		records = append(records, record)
+	rows, err := h.reader(r).Query(
+		fmt.Sprintf("SELECT * FROM %s%s%s LIMIT ? OFFSET ?;", tableName, where, orderBy),
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
 
-		if len(records) == limit {
-			break
-		}
+	records, err := scanRecords(rows, table)
+	if err != nil {
+		internalError(w, err)
+		return
 	}
 
-	if err := rows.Err(); err != nil {
+	if err := h.expandRecords(r, table, records, expand); err != nil {
 		internalError(w, err)
 		return
 	}
+	h.maskRecords(r, table, records)
 
-	err = json.NewEncoder(w).Encode(
+	// deleting or inserting a row doesn't move the newest updated_at,
+	// so collections are validated by the body hash alone
+	h.writeCacheable(w, r,
 		Response{
 			map[string]any{"records": records},
-		},
+		}, "", time.Time{},
 	)
-	if err != nil {
-		internalError(w, err)
-		return
-	}
 }
 
 func (h *handler) readRow(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
 	record := r.Context().Value(RECORD).(map[string]any)
+	table := h.tables[tableName]
 
-	err := json.NewEncoder(w).Encode(
-		Response{
-			map[string]any{"record": record},
-		},
-	)
+	expand, err := expandNames(table, r.URL.Query()["expand"])
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if !h.checkExpand(w, r, table, expand) {
+		return
+	}
+
+	h.maskRecords(r, table, []map[string]any{record})
+
+	// expanded responses also depend on related rows, so only the bare
+	// record keeps the tag If-Match compares against and its updated_at
+	etag := ""
+	var modified time.Time
+	if len(expand) == 0 {
+		etag = recordETag(table, record)
+		modified = lastModified(table, []map[string]any{record})
+	}
+
+	err = h.expandRecords(r, table, []map[string]any{record}, expand)
 	if err != nil {
 		internalError(w, err)
 		return
 	}
+
+	h.writeCacheable(w, r,
+		Response{
+			map[string]any{"record": record},
+		}, etag, modified,
+	)
 }
 
 func (h *handler) createRow(w http.ResponseWriter, r *http.Request) {
 	tableName := r.Context().Value(TABLE).(string)
 	table := h.tables[tableName]
 
-	var requestBody map[string]any
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	requestRows, isBulk, err := decodeRows(r)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	var values []any
-	var placeholders []string
-	var columnNames []string
+	var parent *parentKey
+	if p, ok := r.Context().Value(PARENT).(parentKey); ok {
+		parent = &p
+	}
 
-	for _, col := range table.Columns {
-		if col.IsAutoIncrement {
-			continue
+	if preferences(r)["resolution"] == "merge-duplicates" {
+		if !h.allowed(r, tableName, OpUpdate) {
+			forbidden(w, ErrForbidden(OpUpdate, tableName))
+			return
 		}
+		h.upsertRows(w, r, table, requestRows, parent, isBulk)
+		return
+	}
 
-		val, ok := requestBody[col.Name]
-		if !ok {
-			val = defaultValue(col)
-		}
+	if isBulk {
+		h.createRows(w, r, table, requestRows, parent)
+		return
+	}
 
-		val, err = validateColumnType(col, val)
-		if err != nil {
-			badRequest(w, err)
-			return
-		}
+	values, err := insertValues(table, requestRows[0], parent, h.writeOptions(r))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
 
-		values = append(values, val)
-		placeholders = append(placeholders, "?")
-		columnNames = append(columnNames, col.Name)
+	columnNames := insertColumns(table)
+	tuple, args := valuesTuple(values)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
 	}
+	defer tx.Rollback()
 
-	result, err := h.db.Exec(
-		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
-			tableName, strings.Join(columnNames, ","), strings.Join(placeholders, ","),
-		), values...,
+	result, err := tx.Exec(
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;",
+			tableName, strings.Join(columnNames, ","), tuple,
+		), args...,
 	)
 	if err != nil {
 		internalError(w, err)
@@ -174,6 +234,39 @@ func (h *handler) createRow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var key any = lastID
+	for i, name := range columnNames {
+		if _, ok := values[i].(sqlDefault); !ok && name == table.PrimaryKeyName {
+			key = values[i]
+		}
+	}
+
+	visible, err := h.rowsVisible(tx, r, table, []any{key})
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if !visible {
+		forbidden(w, ErrForbidden(OpCreate, tableName))
+		return
+	}
+
+	audit := h.newAuditLog()
+	if err := audit.capture(tx, AuditCreate, table, key, nil); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if err := h.commit(r, tx, audit); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if wantsRepresentation(r) {
+		h.writeRepresentation(w, r, table, []any{key}, false)
+		return
+	}
+
 	err = json.NewEncoder(w).Encode(
 		Response{
 			map[string]any{table.PrimaryKeyName: lastID},
@@ -197,37 +290,70 @@ func (h *handler) updateRow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var values []any
-	var columnToUpdate []string
+	h.applyUpdate(w, r, table, rowID, requestBody)
+}
 
-	for _, col := range table.Columns {
-		if col.IsAutoIncrement {
-			if _, ok := requestBody[col.Name]; ok {
-				badRequest(w, ErrTypeMismatch(col.Name))
-				return
-			}
-		}
+// applyUpdate writes the columns present in requestBody to the row,
+// it is shared by POST, PATCH and PUT on /{table}/{rowID}
+func (h *handler) applyUpdate(w http.ResponseWriter, r *http.Request, table table, rowID string, requestBody map[string]any) {
+	h.updateRecord(w, r, table, rowID, false, func(map[string]any) (map[string]any, error) {
+		return requestBody, nil
+	})
+}
 
-		val, ok := requestBody[col.Name]
-		if !ok {
-			continue
+// updateRecord runs the update whose body buildBody returns. With lock set
+// the row is read FOR UPDATE first and handed to buildBody, so whatever it
+// checks against the current values still holds when the UPDATE runs;
+// otherwise buildBody gets nil and the body is validated before any
+// transaction is opened.
+func (h *handler) updateRecord(w http.ResponseWriter, r *http.Request, table table, rowID string, lock bool, buildBody func(record map[string]any) (map[string]any, error)) {
+	var columnToUpdate []string
+	var values []any
+	build := func(record map[string]any) bool {
+		requestBody, err := buildBody(record)
+		if err == nil {
+			columnToUpdate, values, err = updateAssignments(table, requestBody, h.writeOptions(r))
 		}
-
-		val, err = validateColumnType(col, val)
-		if err != nil {
+		switch {
+		case errors.Is(err, errTestFailed):
+			writeError(w, err, http.StatusConflict)
+			return false
+		case err != nil:
 			badRequest(w, err)
-			return
+			return false
 		}
+		return true
+	}
+	if !lock && !build(nil) {
+		return
+	}
 
-		values = append(values, val)
-		columnToUpdate = append(columnToUpdate, fmt.Sprintf("%s = ?", col.Name))
+	audit := h.newAuditLog()
+	tx, before, ok := h.beginRowWrite(w, r, table, rowID, audit, lock)
+	if !ok {
+		return
+	}
+	var q querier = h.db
+	if tx != nil {
+		defer tx.Rollback()
+		q = tx
+	}
+	if lock && before == nil {
+		http.Error(w, `{"error": "record not found"}`, http.StatusNotFound)
+		return
+	}
+	if lock && !build(before) {
+		return
 	}
 
-	values = append(values, rowID)
+	where, whereArgs := whereClause(
+		append(h.scopeConditions(r, table), primaryKeyCondition(table, rowID)),
+	)
+	values = append(values, whereArgs...)
 
-	result, err := h.db.Exec(
-		fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?;",
-			tableName, strings.Join(columnToUpdate, ","), table.PrimaryKeyName,
+	result, err := q.Exec(
+		fmt.Sprintf("UPDATE %s SET %s%s;",
+			table.Name, strings.Join(columnToUpdate, ","), where,
 		), values...,
 	)
 	if err != nil {
@@ -241,6 +367,35 @@ func (h *handler) updateRow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	visible, err := h.rowsVisible(q, r, table, []any{rowID})
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if !visible {
+		forbidden(w, ErrForbidden(OpUpdate, table.Name))
+		return
+	}
+
+	if before != nil && rowsAffected > 0 {
+		if err := audit.capture(q, AuditUpdate, table, rowID, before); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if tx != nil {
+		if err := h.commit(r, tx, audit); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if wantsRepresentation(r) {
+		h.writeRepresentation(w, r, table, []any{rowID}, false)
+		return
+	}
+
 	err = json.NewEncoder(w).Encode(
 		Response{
 			map[string]any{"updated": rowsAffected},
@@ -252,14 +407,77 @@ func (h *handler) updateRow(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func updateAssignments(table table, requestBody map[string]any, opts writeOptions) ([]string, []any, error) {
+	if opts.Strict {
+		if err := checkUnknownFields(table, requestBody); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := checkFixedValues(table, requestBody, opts); err != nil {
+		return nil, nil, err
+	}
+
+	var values []any
+	var columnToUpdate []string
+
+	for _, col := range table.Columns {
+		if col.IsAutoIncrement {
+			if _, ok := requestBody[col.Name]; ok {
+				return nil, nil, ErrTypeMismatch(col.Name)
+			}
+		}
+
+		val, ok := requestBody[col.Name]
+		if !ok || !col.writable() {
+			continue
+		}
+
+		if _, ok := val.(sqlDefault); ok {
+			columnToUpdate = append(columnToUpdate, fmt.Sprintf("%s = DEFAULT", col.Name))
+			continue
+		}
+
+		val, err := validateColumnType(col, val)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		values = append(values, val)
+		columnToUpdate = append(columnToUpdate, fmt.Sprintf("%s = ?", col.Name))
+	}
+
+	if len(columnToUpdate) == 0 {
+		return nil, nil, ErrNothingToUpdate
+	}
+
+	return columnToUpdate, values, nil
+}
+
 func (h *handler) deleteRow(w http.ResponseWriter, r *http.Request) {
 	tableName := r.Context().Value(TABLE).(string)
 	rowID := r.PathValue("rowID")
 	table := h.tables[tableName]
 
-	result, err := h.db.Exec(
-		fmt.Sprintf("DELETE FROM %s WHERE %s = ?;", tableName, table.PrimaryKeyName), rowID,
-	)
+	audit := h.newAuditLog()
+	tx, before, ok := h.beginRowWrite(w, r, table, rowID, audit, false)
+	if !ok {
+		return
+	}
+	var q querier = h.db
+	if tx != nil {
+		defer tx.Rollback()
+		q = tx
+	}
+
+	conditions := append(h.rowSecurityConditions(r, table), primaryKeyCondition(table, rowID))
+	query := fmt.Sprintf("DELETE FROM %s", tableName)
+	if col, ok := table.softDeleteColumn(); ok {
+		query = fmt.Sprintf("UPDATE %s SET %s = %s", tableName, col.Name, softDeleteValue(col))
+		conditions = append(conditions, activeCondition(col))
+	}
+	where, args := whereClause(conditions)
+
+	result, err := q.Exec(query+where+";", args...)
 
 	if err != nil {
 		internalError(w, err)
@@ -272,6 +490,21 @@ func (h *handler) deleteRow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a soft-deleted row is still there, after shows the deletion mark
+	if before != nil && rowsAffected > 0 {
+		if err := audit.capture(q, AuditDelete, table, rowID, before); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if tx != nil {
+		if err := h.commit(r, tx, audit); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
 	err = json.NewEncoder(w).Encode(
 		Response{
 			map[string]any{"deleted": rowsAffected},
@@ -283,14 +516,107 @@ func (h *handler) deleteRow(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// writeError encodes the message properly, it often echoes
+// field names and values taken from the request
+func writeError(w http.ResponseWriter, err error, code int) {
+	msg, _ := json.Marshal(map[string]string{"error": err.Error()})
+	http.Error(w, string(msg), code)
+}
+
 func internalError(w http.ResponseWriter, err error) {
-	msg := fmt.Sprintf(`{"error":"%s"}`, err.Error())
-	http.Error(w, msg, http.StatusInternalServerError)
+	writeError(w, err, http.StatusInternalServerError)
 }
 
 func badRequest(w http.ResponseWriter, err error) {
-	msg := fmt.Sprintf(`{"error":"%s"}`, err.Error())
-	http.Error(w, msg, http.StatusBadRequest)
+	writeError(w, err, http.StatusBadRequest)
+}
+
+func scanValues(table table) []any {
+	values := make([]any, len(table.Columns))
+	for i := range values {
+		values[i] = new([]byte)
+	}
+	return values
+}
+
+func makeRecord(table table, values []any) map[string]any {
+	record := make(map[string]any, len(table.Columns))
+	for i := range table.Columns {
+		if !table.Columns[i].readable() {
+			continue
+		}
+		raw := *values[i].(*[]byte)
+		record[table.Columns[i].Name] = convertValue(raw, table.Columns[i].Type)
+	}
+	return record
+}
+
+func selectRecord(q querier, table table, rowID any, conditions []condition) (map[string]any, error) {
+	conditions = append(conditions, primaryKeyCondition(table, rowID))
+	where, args := whereClause(conditions)
+
+	row := q.QueryRow(
+		fmt.Sprintf("SELECT * FROM %s%s;", table.Name, where), args...,
+	)
+
+	values := scanValues(table)
+	if err := row.Scan(values...); err != nil {
+		return nil, err
+	}
+
+	return makeRecord(table, values), nil
+}
+
+// selectRecords loads rows by primary key, in the order of keys
+func selectRecords(q querier, table table, keys []any) ([]map[string]any, error) {
+	if len(keys) == 0 {
+		return []map[string]any{}, nil
+	}
+
+	rows, err := q.Query(
+		fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s);",
+			table.Name, table.PrimaryKeyName, placeholders(len(keys))), keys...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := scanRecords(rows, table)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]map[string]any, len(found))
+	for _, record := range found {
+		byKey[fmt.Sprint(record[table.PrimaryKeyName])] = record
+	}
+
+	records := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		if record, ok := byKey[fmt.Sprint(key)]; ok {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func scanRecords(rows *sql.Rows, table table) ([]map[string]any, error) {
+	defer rows.Close()
+
+	records := []map[string]any{}
+	for rows.Next() {
+		values := scanValues(table)
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		records = append(records, makeRecord(table, values))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, rows.Close()
 }
 
 func convertValue(raw []byte, columnType columnType) any {
@@ -347,6 +673,46 @@ func validateColumnType(col column, val any) (any, error) {
 	}
 }
 
+// checkUnknownFields rejects body keys that don't name a writable column,
+// used in strict mode so typos don't silently disappear
+func checkUnknownFields(table table, requestBody map[string]any) error {
+	var unknown []string
+	for name := range requestBody {
+		if _, ok := readableColumn(table, name); ok && !hasWritableColumn(table, name) {
+			return ErrReadOnlyField(name)
+		}
+		if !hasColumn(table, name) || hiddenColumn(table, name) {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return ErrUnknownFields(unknown)
+	}
+	return nil
+}
+
+// sqlDefault stands for the DEFAULT keyword among column values
+type sqlDefault struct{}
+
+func ErrRequiredField(colName string) error {
+	return fmt.Errorf("field %s is required", colName)
+}
+
+// omittedValue decides what to write for a column missing from the body:
+// the database default when there is one (NULL counts for nullable columns),
+// otherwise an error or, for backwards compatibility, the type's zero value
+func omittedValue(col column, opts writeOptions) (any, error) {
+	if col.DefaultValue.Valid || col.IsNullable {
+		return sqlDefault{}, nil
+	}
+	if opts.RequireFields {
+		return nil, ErrRequiredField(col.Name)
+	}
+	return defaultValue(col), nil
+}
+
 func defaultValue(col column) any {
 	if col.IsNullable {
 		return nil
@@ -0,0 +1,310 @@
+package dbexplorer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNoVersion         = errors.New("record didn't exist at that time")
+	ErrAsOfUnavailable   = errors.New("as_of needs a readable audit log")
+	ErrRevertSoftDeleted = errors.New("record is deleted, restore it first")
+)
+
+func ErrInvalidAsOf(value string) error {
+	return fmt.Errorf("as_of %s is not an RFC 3339 time", value)
+}
+
+// rowHistory lists the audited versions of a row, newest first. The row
+// may be gone, versions are kept when the caller's row filter lets
+// either side of the change through; limit and offset count kept
+// versions, so the sink is paged through until enough are found.
+func (h *handler) rowHistory(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	rowID := r.Context().Value(ROWID).(string)
+	table := h.tables[tableName]
+	reader := h.audit.(AuditReader)
+	conditions := h.rowSecurityConditions(r, table)
+
+	limit := defaultAuditLimit
+	if l, err := strconv.Atoi(r.FormValue("limit")); err == nil && l > 0 {
+		limit = min(l, maxAuditLimit)
+	}
+	offset := 0
+	if o, err := strconv.Atoi(r.FormValue("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	versions := make([]map[string]any, 0, limit)
+	filter := AuditFilter{Table: tableName, Key: rowID, Limit: maxAuditLimit}
+	for len(versions) < limit {
+		entries, err := reader.Read(r.Context(), filter)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		for _, e := range entries {
+			visible, err := h.versionVisible(r, table, e.Before, conditions)
+			if err == nil && !visible {
+				visible, err = h.versionVisible(r, table, e.After, conditions)
+			}
+			if err != nil {
+				internalError(w, err)
+				return
+			}
+			if !visible || len(versions) == limit {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+
+			if e.After != nil {
+				h.maskRecords(r, table, []map[string]any{e.After})
+			}
+			versions = append(versions, map[string]any{
+				"time":       e.Time,
+				"action":     e.Action,
+				"principal":  e.Principal,
+				"request_id": e.RequestID,
+				"record":     e.After,
+			})
+		}
+
+		if len(entries) < filter.Limit {
+			break
+		}
+		filter.Offset += filter.Limit
+	}
+
+	err := json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{"versions": versions},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
+
+// versionVisible reports whether an audited version passes conditions,
+// a missing version never does
+func (h *handler) versionVisible(r *http.Request, table table, version map[string]any, conditions []condition) (bool, error) {
+	if version == nil {
+		return false, nil
+	}
+	return h.recordMatches(r, table, version, conditions)
+}
+
+// versionAt rebuilds the row as it was at asOf from the audit log: the
+// last change up to then tells its state, failing that the first change
+// after it does, and with no change since the row is as it is now.
+// A nil record means the row didn't exist at that time or the caller
+// can't see that version.
+func (h *handler) versionAt(r *http.Request, table table, rowID string, asOf time.Time) (map[string]any, error) {
+	version, audited, err := h.auditedVersion(r, table, rowID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	if audited {
+		// a soft deleted version is as out of reach as the row would be
+		visible, err := h.versionVisible(r, table, version, h.scopeConditions(r, table))
+		if err != nil || !visible {
+			return nil, err
+		}
+		return version, nil
+	}
+
+	record, err := selectRecord(h.reader(r), table, rowID, h.scopeConditions(r, table))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return record, err
+}
+
+// auditedVersion returns the row's version at asOf per the audit log,
+// audited is false when the row hasn't changed since
+func (h *handler) auditedVersion(r *http.Request, table table, rowID string, asOf time.Time) (version map[string]any, audited bool, err error) {
+	reader := h.audit.(AuditReader)
+
+	// the table sink keeps microseconds
+	boundary := asOf.Add(time.Microsecond)
+	entries, err := reader.Read(r.Context(), AuditFilter{
+		Table: table.Name, Key: rowID, Until: boundary, Limit: 1,
+	})
+	if err != nil || len(entries) > 0 {
+		return firstAfter(entries), err == nil, err
+	}
+
+	entries, err = reader.Read(r.Context(), AuditFilter{
+		Table: table.Name, Key: rowID, Since: boundary, Limit: 1, Ascending: true,
+	})
+	if err != nil || len(entries) > 0 {
+		return firstBefore(entries), err == nil, err
+	}
+
+	return nil, false, nil
+}
+
+func firstAfter(entries []AuditEntry) map[string]any {
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[0].After
+}
+
+func firstBefore(entries []AuditEntry) map[string]any {
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[0].Before
+}
+
+func parseAsOf(r *http.Request) (time.Time, error) {
+	value := r.URL.Query().Get("as_of")
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidAsOf(value)
+	}
+	return asOf, nil
+}
+
+// withAsOf hands GET /{table}/{rowID}?as_of=... to readRowAsOf before
+// withRowAccess looks the row up, it may have been deleted since
+func (h *handler) withAsOf(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has("as_of") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := h.audit.(AuditReader); !ok {
+			badRequest(w, ErrAsOfUnavailable)
+			return
+		}
+		h.withAuditedRow(http.HandlerFunc(h.readRowAsOf)).ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) readRowAsOf(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	rowID := r.Context().Value(ROWID).(string)
+	table := h.tables[tableName]
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	record, err := h.versionAt(r, table, rowID, asOf)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if record == nil {
+		http.Error(w, `{"error": "record not found"}`, http.StatusNotFound)
+		return
+	}
+	h.maskRecords(r, table, []map[string]any{record})
+
+	err = json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{"record": record},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
+
+// revertRow handles POST /{table}/{rowID}/_revert?as_of=...: the row's
+// writable columns are set back to their values at that time through
+// applyUpdate, so permissions, row filters, If-Match and auditing apply.
+// A row deleted since is inserted again through upsertRows, which needs
+// the create permission on top; a soft deleted one has to be restored.
+func (h *handler) revertRow(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	rowID := r.Context().Value(ROWID).(string)
+	table := h.tables[tableName]
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	version, err := h.versionAt(r, table, rowID, asOf)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if version == nil {
+		writeError(w, ErrNoVersion, http.StatusConflict)
+		return
+	}
+
+	requestBody := map[string]any{}
+	for _, col := range table.Columns {
+		if col.IsAutoIncrement || col.Name == table.PrimaryKeyName || !col.writable() {
+			continue
+		}
+		if val, ok := version[col.Name]; ok {
+			requestBody[col.Name] = val
+		}
+	}
+
+	_, err = selectRecord(h.db, table, rowID, h.scopeConditions(r, table))
+	if err == nil {
+		h.applyUpdate(w, r, table, rowID, requestBody)
+		return
+	}
+	if err != sql.ErrNoRows {
+		internalError(w, err)
+		return
+	}
+
+	_, err = selectRecord(h.db, table, rowID, h.rowSecurityConditions(r, table))
+	switch err {
+	case nil:
+		writeError(w, ErrRevertSoftDeleted, http.StatusConflict)
+	case sql.ErrNoRows:
+		h.recreateRow(w, r, table, version, requestBody)
+	default:
+		internalError(w, err)
+	}
+}
+
+// recreateRow inserts a reverted row that was hard deleted since
+func (h *handler) recreateRow(w http.ResponseWriter, r *http.Request, table table, version, requestBody map[string]any) {
+	if !h.allowed(r, table.Name, OpCreate) {
+		forbidden(w, ErrForbidden(OpCreate, table.Name))
+		return
+	}
+
+	var exists int
+	err := h.db.QueryRow(
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = ?;", table.Name, table.PrimaryKeyName),
+		version[table.PrimaryKeyName],
+	).Scan(&exists)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	// the key is taken by a row out of the caller's reach
+	if exists > 0 {
+		http.Error(w, `{"error": "record not found"}`, http.StatusNotFound)
+		return
+	}
+
+	requestBody[table.PrimaryKeyName] = version[table.PrimaryKeyName]
+	h.upsertRows(w, r, table, []map[string]any{requestBody}, nil, false)
+}
@@ -0,0 +1,245 @@
+package dbexplorer
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWTConfig configures bearer token validation. Tokens must be signed
+// with HS256 using Secret or an "oct" key from the JWKS file, or with
+// RS256 using an "RSA" key from the JWKS file.
+type JWTConfig struct {
+	Secret   []byte
+	JWKSFile string
+	// Issuer and Audience are checked when set
+	Issuer   string
+	Audience string
+	// RolesClaim names the claim listing the caller's roles, "roles" by default
+	RolesClaim string
+	// Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+type jwtAuthenticator struct {
+	config   JWTConfig
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// NewJWTAuthenticator reads the JWKS file once, keys are not reloaded
+func NewJWTAuthenticator(config JWTConfig) (Authenticator, error) {
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	a := &jwtAuthenticator{
+		config:   config,
+		hmacKeys: map[string][]byte{},
+		rsaKeys:  map[string]*rsa.PublicKey{},
+	}
+	if len(config.Secret) > 0 {
+		a.hmacKeys[""] = config.Secret
+	}
+
+	if config.JWKSFile != "" {
+		if err := a.loadJWKS(config.JWKSFile); err != nil {
+			return nil, fmt.Errorf("jwks %s: %w", config.JWKSFile, err)
+		}
+	}
+
+	if len(a.hmacKeys) == 0 && len(a.rsaKeys) == 0 {
+		return nil, errors.New("jwt: no secret or keys configured")
+	}
+	return a, nil
+}
+
+func (a *jwtAuthenticator) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return err
+	}
+
+	for _, key := range jwks.Keys {
+		switch key.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", key.Kid, err)
+			}
+			a.hmacKeys[key.Kid] = secret
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil {
+				return fmt.Errorf("key %q: invalid modulus or exponent", key.Kid)
+			}
+			a.rsaKeys[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		}
+	}
+	return nil
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r, "Bearer")
+	if !ok {
+		return nil, nil
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{Claims: claims}
+	principal.Subject, _ = claims["sub"].(string)
+	switch roles := claims[a.config.RolesClaim].(type) {
+	case string:
+		principal.Roles = strings.Fields(roles)
+	case []any:
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, role)
+			}
+		}
+	}
+	return principal, nil
+}
+
+// verify checks the signature and the registered claims of a compact JWS
+// and returns its payload
+func (a *jwtAuthenticator) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+
+	// the key type is chosen by the algorithm, so an RSA public key
+	// can never be used as an HMAC secret
+	switch header.Alg {
+	case "HS256":
+		secret, ok := a.hmacKeys[header.Kid]
+		if !ok {
+			secret, ok = a.hmacKeys[""]
+		}
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, ErrInvalidToken
+		}
+	case "RS256":
+		key, ok := a.rsaKeys[header.Kid]
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *jwtAuthenticator) checkClaims(claims map[string]any) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(a.config.Leeway)) {
+			return ErrTokenExpired
+		}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(a.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrInvalidToken
+		}
+	}
+
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return ErrInvalidToken
+	}
+	if a.config.Audience != "" {
+		var audiences []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audiences = []string{aud}
+		case []any:
+			for _, item := range aud {
+				if s, ok := item.(string); ok {
+					audiences = append(audiences, s)
+				}
+			}
+		}
+		if !slices.Contains(audiences, a.config.Audience) {
+			return ErrInvalidToken
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
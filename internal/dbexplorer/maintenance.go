@@ -0,0 +1,115 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// maintenance pauses writes at runtime; unlike WithReadOnly it can be
+// switched on and off while serving, and answers 503 so clients retry
+type maintenance struct {
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+}
+
+type maintenanceStatus struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+func (m *maintenance) status() maintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maintenanceStatus{m.enabled, m.reason}
+}
+
+func (m *maintenance) set(status maintenanceStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = status.Enabled
+	m.reason = status.Reason
+	if !m.enabled {
+		m.reason = ""
+	}
+}
+
+// writesOff reports whether rejectWrite stops writes
+func (h *handler) writesOff() bool {
+	return h.readOnly || h.maintenance.status().Enabled
+}
+
+// rejectWrite answers 405 when the explorer is read-only and 503 during
+// maintenance, reporting whether the write has to stop here
+func (h *handler) rejectWrite(w http.ResponseWriter, r *http.Request) bool {
+	if h.readOnly {
+		w.Header().Set("Allow", h.readMethods(r))
+		http.Error(w, `{"error": "explorer is read-only"}`, http.StatusMethodNotAllowed)
+		return true
+	}
+
+	status := h.maintenance.status()
+	if !status.Enabled {
+		return false
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	err := json.NewEncoder(w).Encode(
+		map[string]any{"error": "writes are paused for maintenance", "reason": status.Reason},
+	)
+	if err != nil {
+		h.logger.Error("writing maintenance response", "err", err)
+	}
+	return true
+}
+
+// readMethods lists what a read-only explorer still allows on the
+// request's path for the Allow header: GET and HEAD where a GET route
+// serves it, nothing otherwise. Every path matches the "GET /" listing,
+// which only serves the root.
+func (h *handler) readMethods(r *http.Request) string {
+	get := r.Clone(r.Context())
+	get.Method = http.MethodGet
+	_, pattern := h.routes.Handler(get)
+	if pattern == "" || (pattern == "GET /" && r.URL.Path != "/") {
+		return ""
+	}
+	return "GET, HEAD"
+}
+
+// maintenanceMode serves GET and PUT /_maintenance,
+// PUT takes {"enabled": true, "reason": "..."}
+func (h *handler) maintenanceMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var status maintenanceStatus
+		err := json.NewDecoder(r.Body).Decode(&status)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		h.maintenance.set(status)
+		h.logger.Info("maintenance mode changed", "enabled", status.Enabled, "reason", status.Reason)
+	}
+
+	status := h.maintenance.status()
+	err := json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{"enabled": status.Enabled, "reason": status.Reason},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
+
+// reader picks the connection for a request: reads go to the replica
+// when there is one, everything else and the writes' own lookups stay
+// on the primary so they see their latest data
+func (h *handler) reader(r *http.Request) querier {
+	if h.replica != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return h.replica
+	}
+	return h.db
+}
@@ -0,0 +1,143 @@
+package dbexplorer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+type Masking string
+
+const (
+	// MaskRedact replaces the value with "***"
+	MaskRedact Masking = "redact"
+	// MaskHash replaces the value with its hex SHA-256, so equal values
+	// can still be matched; low-entropy values can be guessed from it
+	MaskHash Masking = "hash"
+	// MaskPartial keeps the first character, and the domain of emails,
+	// e.g. "r***@example.com"
+	MaskPartial Masking = "partial"
+	// MaskNull serves the column as null
+	MaskNull Masking = "null"
+)
+
+const redacted = "***"
+
+// maskRule masks Column in tables matching the Table pattern
+// for every caller without one of ExceptRoles
+type maskRule struct {
+	Table       string
+	Column      string
+	Strategy    Masking
+	ExceptRoles []string
+}
+
+func ErrMaskedField(colName string) error {
+	return fmt.Errorf("field %s is masked", colName)
+}
+
+func (m Masking) apply(val any) any {
+	if val == nil {
+		return nil
+	}
+
+	switch m {
+	case MaskNull:
+		return nil
+	case MaskHash:
+		sum := sha256.Sum256([]byte(fmt.Sprint(val)))
+		return hex.EncodeToString(sum[:])
+	case MaskPartial:
+		s, ok := val.(string)
+		if !ok || s == "" {
+			return redacted
+		}
+		local, domain, isEmail := strings.Cut(s, "@")
+		if isEmail && local != "" {
+			return firstRune(local) + redacted + "@" + domain
+		}
+		return firstRune(s) + redacted
+	}
+	return redacted
+}
+
+// firstRune keeps a whole character, a byte slice would cut Cyrillic in half
+func firstRune(s string) string {
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size]
+}
+
+func (h *handler) registerMasks(t *table) error {
+	for _, rule := range h.maskRules {
+		ok, err := path.Match(rule.Table, t.Name)
+		if err != nil {
+			return fmt.Errorf("table pattern %q: %w", rule.Table, err)
+		}
+		if !ok {
+			continue
+		}
+		if !slices.Contains([]Masking{MaskRedact, MaskHash, MaskPartial, MaskNull}, rule.Strategy) {
+			return fmt.Errorf("unknown masking %q for %s.%s", rule.Strategy, t.Name, rule.Column)
+		}
+		if !hasColumn(*t, rule.Column) {
+			if rule.Table == t.Name {
+				return ErrUnknownColumn(t.Name, rule.Column)
+			}
+			continue
+		}
+		if rule.Column == t.PrimaryKeyName {
+			return fmt.Errorf("primary key %s of table %s can't be masked", rule.Column, t.Name)
+		}
+		t.Masks[rule.Column] = rule
+	}
+	return nil
+}
+
+// masking returns the strategy applied to the column for this caller
+func (h *handler) masking(r *http.Request, table table, colName string) (Masking, bool) {
+	rule, ok := table.Masks[colName]
+	if !ok || h.hasAdminRole(r) {
+		return "", false
+	}
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		for _, role := range principal.Roles {
+			if slices.Contains(rule.ExceptRoles, role) {
+				return "", false
+			}
+		}
+	}
+	return rule.Strategy, true
+}
+
+// maskRecords rewrites masked columns in place, right before records
+// are served or hashed into an ETag
+func (h *handler) maskRecords(r *http.Request, table table, records []map[string]any) {
+	for colName := range table.Masks {
+		strategy, ok := h.masking(r, table, colName)
+		if !ok {
+			continue
+		}
+		for _, record := range records {
+			if val, ok := record[colName]; ok {
+				record[colName] = strategy.apply(val)
+			}
+		}
+	}
+}
+
+// checkMaskedFilters refuses filters on masked columns,
+// otherwise the value could be found by bisecting with gt/lt or like
+func (h *handler) checkMaskedFilters(r *http.Request, table table) error {
+	query := r.URL.Query()
+	for colName := range table.Masks {
+		if _, ok := h.masking(r, table, colName); ok && query.Has(colName) {
+			return ErrMaskedField(colName)
+		}
+	}
+	return nil
+}
@@ -3,7 +3,6 @@ package dbexplorer
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"net/http"
 )
 
@@ -13,6 +12,9 @@ const (
 	TABLE ctxKey = iota
 	ROWID
 	RECORD
+	PARENT
+	PRINCIPAL
+	REQUESTID
 )
 
 func (h *handler) withTableAccess(handler http.Handler) http.Handler {
@@ -29,23 +31,34 @@ func (h *handler) withTableAccess(handler http.Handler) http.Handler {
 	})
 }
 
+func (h *handler) withWriteAccess(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tableName := r.Context().Value(TABLE).(string)
+
+		if h.rejectWrite(w, r) {
+			return
+		}
+		if h.tables[tableName].IsView {
+			http.Error(w, `{"error": "view is read-only"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func (h *handler) withRowAccess(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tableName := r.Context().Value(TABLE).(string)
 		rowID := r.PathValue("rowID")
 		table := h.tables[tableName]
 
-		row := h.db.QueryRow(
-			fmt.Sprintf("SELECT * FROM %s WHERE %s = ?;",
-				tableName, table.PrimaryKeyName), rowID,
-		)
-
-		values := make([]any, len(table.Columns))
-		for i := range values {
-			values[i] = new([]byte)
+		if table.PrimaryKeyName == "" {
+			http.Error(w, `{"error": "table has no primary key"}`, http.StatusNotFound)
+			return
 		}
 
-		err := row.Scan(values...)
+		record, err := selectRecord(h.reader(r), table, rowID, h.scopeConditions(r, table))
 		if err == sql.ErrNoRows {
 			http.Error(w, `{"error": "record not found"}`, http.StatusNotFound)
 			return
@@ -54,19 +67,47 @@ func (h *handler) withRowAccess(handler http.Handler) http.Handler {
 			internalError(w, err)
 			return
 		}
-		if row.Err() != nil {
-			internalError(w, err)
+
+		ctx := context.WithValue(r.Context(), RECORD, record)
+		ctx = context.WithValue(ctx, ROWID, rowID)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withAuditedRow is withRowAccess for routes that read the audit log:
+// the row may have been deleted since, so it isn't looked up and
+// RECORD isn't set, the handlers check visibility per version
+func (h *handler) withAuditedRow(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tableName := r.Context().Value(TABLE).(string)
+
+		if h.tables[tableName].PrimaryKeyName == "" {
+			http.Error(w, `{"error": "table has no primary key"}`, http.StatusNotFound)
 			return
 		}
 
-		record := make(map[string]any, len(table.Columns))
-		for i := range table.Columns {
-			raw := *values[i].(*[]byte)
-			record[table.Columns[i].Name] = convertValue(raw, table.Columns[i].Type)
+		ctx := context.WithValue(r.Context(), ROWID, r.PathValue("rowID"))
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *handler) withChildAccess(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tableName := r.Context().Value(TABLE).(string)
+		record := r.Context().Value(RECORD).(map[string]any)
+		table := h.tables[tableName]
+
+		rel, ok := table.Relations[r.PathValue("childTable")]
+		if !ok || !rel.Many {
+			http.Error(w, `{"error": "unknown relation"}`, http.StatusNotFound)
+			return
 		}
 
-		ctx := context.WithValue(r.Context(), RECORD, record)
-		ctx = context.WithValue(ctx, ROWID, rowID)
+		ctx := context.WithValue(r.Context(), TABLE, rel.Table)
+		ctx = context.WithValue(ctx, PARENT, parentKey{
+			Column: rel.RefColumn,
+			Value:  record[rel.Column],
+		})
 		handler.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
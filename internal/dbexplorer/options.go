@@ -0,0 +1,224 @@
+package dbexplorer
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const defaultMaxAffectedRows = 1000
+
+type Option func(*handler)
+
+// WithMaxAffectedRows caps how many rows a single filtered update or delete
+// may touch, zero disables the check
+func WithMaxAffectedRows(n int) Option {
+	return func(h *handler) {
+		h.maxAffectedRows = n
+	}
+}
+
+// WithStrictFields makes writes fail with 400 on body fields that don't
+// match any column instead of ignoring them
+func WithStrictFields() Option {
+	return func(h *handler) {
+		h.strictFields = true
+	}
+}
+
+// WithRequiredFields makes inserts fail with 400 when a NOT NULL column
+// without a database default is missing, instead of writing its zero value
+func WithRequiredFields() Option {
+	return func(h *handler) {
+		h.requireFields = true
+	}
+}
+
+// WithSoftDelete makes deletes on the table set column (e.g. deleted_at)
+// instead of removing rows; such rows are hidden from reads by default
+// and can be brought back with POST /{table}/{rowID}/_restore
+func WithSoftDelete(table, column string) Option {
+	return func(h *handler) {
+		h.softDeleteColumns[table] = column
+	}
+}
+
+// WithVersionColumn makes record ETags follow the value of column
+// (e.g. a version counter maintained by the application or a trigger)
+// instead of a hash of the whole record
+func WithVersionColumn(table, column string) Option {
+	return func(h *handler) {
+		h.versionColumns[table] = column
+	}
+}
+
+// WithRowFilter restricts every read and write on the table to rows
+// matching a predicate built from the caller's token claims, e.g.
+// "tenant_id = {claims.tenant}". Columns compared for equality with a
+// claim are filled in on insert and can't be changed; callers with the
+// admin role aren't filtered.
+func WithRowFilter(table, predicate string) Option {
+	return func(h *handler) {
+		h.rowFilters[table] = predicate
+	}
+}
+
+// WithMasking serves the column masked with strategy to every caller except
+// those having one of exceptRoles or the admin role; filtering on it is
+// refused for them, e.g. WithMasking("users", "email", MaskPartial, "support")
+func WithMasking(table, column string, strategy Masking, exceptRoles ...string) Option {
+	return func(h *handler) {
+		h.maskRules = append(h.maskRules, maskRule{table, column, strategy, exceptRoles})
+	}
+}
+
+// WithCacheControl sets the Cache-Control header sent with table and record
+// reads, e.g. "public, max-age=60"; by default none is sent. With
+// authenticators the responses also carry Vary: Authorization, X-API-Key,
+// an authenticator reading other headers calls for a "private" value.
+func WithCacheControl(value string) Option {
+	return func(h *handler) {
+		h.cacheControl = value
+	}
+}
+
+// WithTables exposes only the tables and views matching the given names
+// or path.Match patterns such as "shop_*", the rest of the database stays
+// invisible to the API
+func WithTables(patterns ...string) Option {
+	return func(h *handler) {
+		h.includeTables = append(h.includeTables, patterns...)
+	}
+}
+
+// WithExcludeTables hides tables matching the patterns,
+// taking precedence over WithTables
+func WithExcludeTables(patterns ...string) Option {
+	return func(h *handler) {
+		h.excludeTables = append(h.excludeTables, patterns...)
+	}
+}
+
+// WithHiddenColumns removes columns from every read, filter and write on
+// tables matching the table pattern, e.g. WithHiddenColumns("users", "password")
+func WithHiddenColumns(table string, columns ...string) Option {
+	return func(h *handler) {
+		h.columnRules = append(h.columnRules, columnRule{table, columns, accessHidden})
+	}
+}
+
+// WithReadOnlyColumns serves the columns but ignores them in write bodies,
+// or rejects them in strict mode
+func WithReadOnlyColumns(table string, columns ...string) Option {
+	return func(h *handler) {
+		h.columnRules = append(h.columnRules, columnRule{table, columns, accessReadOnly})
+	}
+}
+
+// WithWriteOnlyColumns accepts the columns in write bodies
+// but never serves them or lets them be filtered on
+func WithWriteOnlyColumns(table string, columns ...string) Option {
+	return func(h *handler) {
+		h.columnRules = append(h.columnRules, columnRule{table, columns, accessWriteOnly})
+	}
+}
+
+// WithReadOnly rejects every write, including batches and stored
+// routine calls, with 405; routines declared NO SQL or READS SQL DATA
+// can still be called, inside a READ ONLY transaction since MySQL doesn't
+// hold them to the declaration
+func WithReadOnly() Option {
+	return func(h *handler) {
+		h.readOnly = true
+	}
+}
+
+// WithMaintenance starts the explorer with writes paused: they're
+// rejected with 503 and reason until an admin sends
+// PUT /_maintenance {"enabled": false} or Explorer.SetMaintenance is called
+func WithMaintenance(reason string) Option {
+	return func(h *handler) {
+		h.maintenance.set(maintenanceStatus{Enabled: true, Reason: reason})
+	}
+}
+
+// WithReadReplica sends table and row reads, including expanded
+// relations, to replica; writes and the lookups they make stay on the
+// primary, so a read right after a write may not see it yet
+func WithReadReplica(replica *sql.DB) Option {
+	return func(h *handler) {
+		h.replica = replica
+	}
+}
+
+// WithMaxPageSize caps the limit parameter of table reads,
+// larger values are silently lowered to n
+func WithMaxPageSize(n int) Option {
+	return func(h *handler) {
+		h.maxPageSize = n
+	}
+}
+
+// WithPathPrefix serves the API under prefix, e.g. "/db", when the
+// explorer is used directly as the server handler; see also Explorer.Mount
+func WithPathPrefix(prefix string) Option {
+	return func(h *handler) {
+		h.pathPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithLogger logs schema loading and every request to logger,
+// nothing is logged by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *handler) {
+		h.logger = logger
+	}
+}
+
+// WithAuthenticator requires every request to be authenticated by one of
+// the authenticators, tried in order; middleware added with WithMiddleware
+// runs after authentication and can get the caller with PrincipalFromContext
+func WithAuthenticator(authenticators ...Authenticator) Option {
+	return func(h *handler) {
+		h.authenticators = append(h.authenticators, authenticators...)
+	}
+}
+
+// WithPolicies turns on authorization: every request needs a policy
+// allowing the operation on the table for one of the caller's roles,
+// otherwise it gets 403; tables a caller can't access are left out of GET /
+func WithPolicies(policies ...Policy) Option {
+	return func(h *handler) {
+		h.policies = append(h.policies, policies...)
+	}
+}
+
+// WithAdminRole names the role that bypasses policies and may restore
+// soft-deleted rows or read them with include_deleted, "admin" by default
+func WithAdminRole(role string) Option {
+	return func(h *handler) {
+		h.adminRole = role
+	}
+}
+
+// WithAudit records every row created, updated, deleted or restored
+// through the API, with its values before and after, to sink, and serves
+// the entries to callers with the admin role on GET /_audit when the sink
+// is an AuditReader. A TxAuditSink such as TableAuditSink is written in the
+// same transaction as the change.
+// A readable sink also enables GET /{table}/{rowID}/_history, reading a row
+// as it was with ?as_of= and POST /{table}/{rowID}/_revert?as_of=.
+// Stored procedure calls aren't audited.
+func WithAudit(sink AuditSink) Option {
+	return func(h *handler) {
+		h.audit = sink
+	}
+}
+
+// WithMiddleware wraps the API routes, the first middleware is the outermost
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(h *handler) {
+		h.middleware = append(h.middleware, middleware...)
+	}
+}
@@ -0,0 +1,163 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+func ErrUnknownField(colName string) error {
+	return fmt.Errorf("unknown field %s", colName)
+}
+
+var errTestFailed = errors.New("test failed")
+
+func ErrTestFailed(colName string) error {
+	return fmt.Errorf("%w for field %s", errTestFailed, colName)
+}
+
+// patchRow handles PATCH with either a JSON Merge Patch (RFC 7396), which
+// maps onto the same partial update as POST, or a JSON Patch (RFC 6902)
+// restricted to top-level members, i.e. columns
+func (h *handler) patchRow(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	rowID := r.Context().Value(ROWID).(string)
+	table := h.tables[tableName]
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/merge-patch+json", "application/json", "":
+		var requestBody map[string]any
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		h.applyUpdate(w, r, table, rowID, requestBody)
+	case "application/json-patch+json":
+		var operations []jsonPatchOperation
+		err := json.NewDecoder(r.Body).Decode(&operations)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+
+		// a test against a masked value would reveal it one guess at a time
+		for _, op := range operations {
+			colName := strings.TrimPrefix(op.Path, "/")
+			if _, ok := h.masking(r, table, colName); ok && op.Op == "test" {
+				badRequest(w, ErrMaskedField(colName))
+				return
+			}
+		}
+
+		// test ops compare against the row locked for the write,
+		// so nothing can change it between the test and the update
+		h.updateRecord(w, r, table, rowID, true, func(record map[string]any) (map[string]any, error) {
+			return jsonPatchBody(table, record, operations)
+		})
+	default:
+		http.Error(w, `{"error": "unsupported patch format"}`, http.StatusUnsupportedMediaType)
+	}
+}
+
+// replaceRow handles PUT /{table}/{rowID}: every column not present in the
+// body is reset to its default, the primary key itself can't be changed
+func (h *handler) replaceRow(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	rowID := r.Context().Value(ROWID).(string)
+	table := h.tables[tableName]
+
+	var requestBody map[string]any
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if requestBody == nil {
+		requestBody = map[string]any{}
+	}
+
+	opts := h.writeOptions(r)
+	for _, col := range table.Columns {
+		if col.IsAutoIncrement || col.Name == table.PrimaryKeyName || !col.writable() {
+			continue
+		}
+		// row filter columns keep their value, updateAssignments checks them
+		if table.RowFilter != nil && table.RowFilter.Fixed[col.Name] != "" {
+			continue
+		}
+		if _, ok := requestBody[col.Name]; !ok {
+			requestBody[col.Name], err = omittedValue(col, opts)
+			if err != nil {
+				badRequest(w, err)
+				return
+			}
+		}
+	}
+
+	h.applyUpdate(w, r, table, rowID, requestBody)
+}
+
+// jsonPatchBody turns JSON Patch operations into an update body:
+// add and replace set a column, remove sets it to NULL and test compares
+// against the current record, failing the whole patch on mismatch
+func jsonPatchBody(table table, record map[string]any, operations []jsonPatchOperation) (map[string]any, error) {
+	requestBody := map[string]any{}
+	for _, op := range operations {
+		colName := strings.TrimPrefix(op.Path, "/")
+		colName = strings.NewReplacer("~1", "/", "~0", "~").Replace(colName)
+		if !strings.HasPrefix(op.Path, "/") || !hasColumn(table, colName) || hiddenColumn(table, colName) {
+			return nil, ErrUnknownField(colName)
+		}
+		if op.Op != "test" && !hasWritableColumn(table, colName) {
+			return nil, ErrReadOnlyField(colName)
+		}
+
+		switch op.Op {
+		case "add", "replace":
+			requestBody[colName] = op.Value
+		case "remove":
+			requestBody[colName] = nil
+		case "test":
+			if _, ok := readableColumn(table, colName); !ok {
+				return nil, ErrUnknownField(colName)
+			}
+			current, ok := requestBody[colName]
+			if !ok {
+				current = record[colName]
+			}
+			if !jsonEqual(current, op.Value) {
+				return nil, ErrTestFailed(colName)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported patch operation %s", op.Op)
+		}
+	}
+	return requestBody, nil
+}
+
+// jsonEqual compares values the way they look in JSON,
+// so a stored int matches the float64 decoded from the request
+func jsonEqual(a, b any) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	var aValue, bValue any
+	json.Unmarshal(aJSON, &aValue)
+	json.Unmarshal(bJSON, &bValue)
+	return reflect.DeepEqual(aValue, bValue)
+}
@@ -0,0 +1,166 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+)
+
+type Operation string
+
+const (
+	OpRead   Operation = "read"
+	OpCreate Operation = "create"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+	// OpCall applies to stored routines, Tables then lists routine names
+	OpCall Operation = "call"
+)
+
+// AnyRole in Policy.Role matches every caller, authenticated or not
+const AnyRole = "*"
+
+const defaultAdminRole = "admin"
+
+// Policy lets callers having Role perform Operations on the tables
+// (or routines) matching any of the Tables patterns
+type Policy struct {
+	Role       string      `json:"role"`
+	Tables     []string    `json:"tables"`
+	Operations []Operation `json:"operations"`
+}
+
+func ErrForbidden(op Operation, name string) error {
+	return fmt.Errorf("not allowed to %s %s", op, name)
+}
+
+func forbidden(w http.ResponseWriter, err error) {
+	writeError(w, err, http.StatusForbidden)
+}
+
+func (h *handler) checkPolicies() error {
+	for _, p := range h.policies {
+		if p.Role == "" {
+			return fmt.Errorf("policy without role")
+		}
+		for _, pattern := range p.Tables {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("policy for %s: table pattern %q: %w", p.Role, pattern, err)
+			}
+		}
+		for _, op := range p.Operations {
+			if !slices.Contains([]Operation{OpRead, OpCreate, OpUpdate, OpDelete, OpCall}, op) {
+				return fmt.Errorf("policy for %s: unknown operation %q", p.Role, op)
+			}
+		}
+	}
+	return nil
+}
+
+// isAdmin guards the admin endpoints and include_deleted; only an API
+// with neither authentication nor policies is open to everyone, otherwise
+// the caller needs the admin role
+func (h *handler) isAdmin(r *http.Request) bool {
+	if len(h.authenticators) == 0 && len(h.policies) == 0 {
+		return true
+	}
+	return h.hasAdminRole(r)
+}
+
+// allowed checks the caller's roles against the policies, without policies
+// every caller may do everything; invalid patterns are rejected by
+// checkPolicies and never match here
+func (h *handler) allowed(r *http.Request, name string, op Operation) bool {
+	if len(h.policies) == 0 || h.hasAdminRole(r) {
+		return true
+	}
+
+	var roles []string
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		roles = principal.Roles
+	}
+
+	for _, p := range h.policies {
+		if p.Role != AnyRole && !slices.Contains(roles, p.Role) {
+			continue
+		}
+		if !slices.Contains(p.Operations, op) {
+			continue
+		}
+		for _, pattern := range p.Tables {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedAny reports whether the caller may do anything at all with the table
+func (h *handler) allowedAny(r *http.Request, name string) bool {
+	for _, op := range []Operation{OpRead, OpCreate, OpUpdate, OpDelete} {
+		if h.allowed(r, name, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// withPermission sits right after withTableAccess or withChildAccess
+// and checks op against the table in the context
+func (h *handler) withPermission(op Operation, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tableName := r.Context().Value(TABLE).(string)
+
+		if !h.allowed(r, tableName, op) {
+			forbidden(w, ErrForbidden(op, tableName))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// withAdmin guards endpoints that bypass the usual row scoping
+func (h *handler) withAdmin(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.isAdmin(r) {
+			http.Error(w, `{"error": "admin role required"}`, http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// withAdminRole guards endpoints serving data unmasked and unfiltered,
+// they need the admin role even on an API without access control
+func (h *handler) withAdminRole(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.hasAdminRole(r) {
+			http.Error(w, `{"error": "admin role required"}`, http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// checkExpand makes sure the caller may read every table pulled in
+// through ?expand=, writing 403 otherwise
+func (h *handler) checkExpand(w http.ResponseWriter, r *http.Request, table table, names []string) bool {
+	for _, name := range names {
+		rel := table.Relations[name]
+		if !h.allowed(r, rel.Table, OpRead) {
+			forbidden(w, ErrForbidden(OpRead, rel.Table))
+			return false
+		}
+		// the related record would reveal the masked key
+		if _, ok := h.masking(r, table, rel.Column); ok {
+			badRequest(w, ErrMaskedField(rel.Column))
+			return false
+		}
+	}
+	return true
+}
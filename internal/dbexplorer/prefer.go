@@ -0,0 +1,90 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// preferences parses the Prefer request header (RFC 7240),
+// e.g. "Prefer: resolution=merge-duplicates, return=representation"
+func preferences(r *http.Request) map[string]string {
+	prefs := map[string]string{}
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if name == "" {
+				continue
+			}
+			prefs[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return prefs
+}
+
+// writeOptions controls how request bodies are turned into column values
+type writeOptions struct {
+	// Strict rejects body fields that don't match any column
+	Strict bool
+	// RequireFields rejects inserts missing a NOT NULL column without default
+	RequireFields bool
+	// Claims of the caller fill in and check row filter columns
+	// unless BypassRowFilters is set
+	Claims           map[string]any
+	BypassRowFilters bool
+}
+
+// writeOptions starts from the server-wide settings, strictness can be
+// overridden per request by "Prefer: handling=strict" or "handling=lenient"
+func (h *handler) writeOptions(r *http.Request) writeOptions {
+	opts := writeOptions{
+		Strict:           h.strictFields,
+		RequireFields:    h.requireFields,
+		Claims:           requestClaims(r),
+		BypassRowFilters: h.hasAdminRole(r),
+	}
+
+	switch preferences(r)["handling"] {
+	case "strict":
+		opts.Strict = true
+	case "lenient":
+		opts.Strict = false
+	}
+	return opts
+}
+
+// wantsRepresentation reports whether the client asked to get the written
+// records back instead of keys or counts ("Prefer: return=representation")
+func wantsRepresentation(r *http.Request) bool {
+	return preferences(r)["return"] == "representation"
+}
+
+// writeRepresentation re-reads written rows by primary key and responds
+// with them, as "record" for a single write and "records" for a bulk one
+func (h *handler) writeRepresentation(w http.ResponseWriter, r *http.Request, table table, keys []any, isBulk bool) {
+	records, err := selectRecords(h.db, table, keys)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	h.maskRecords(r, table, records)
+
+	response := map[string]any{"records": records}
+	if !isBulk {
+		if len(records) == 0 {
+			http.Error(w, `{"error": "record not found"}`, http.StatusNotFound)
+			return
+		}
+		response = map[string]any{"record": records[0]}
+		w.Header().Set("ETag", recordETag(table, records[0]))
+	}
+
+	w.Header().Set("Preference-Applied", "return=representation")
+	err = json.NewEncoder(w).Encode(
+		Response{response},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
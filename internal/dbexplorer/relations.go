@@ -0,0 +1,205 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type relation struct {
+	Name      string
+	Table     string
+	Column    string
+	RefColumn string
+	Many      bool
+}
+
+// parentKey pins the foreign key of a child table to its parent row
+type parentKey struct {
+	Column string
+	Value  any
+}
+
+func (p parentKey) condition() condition {
+	return condition{
+		SQL:  p.Column + " = ?",
+		Args: []any{p.Value},
+	}
+}
+
+func ErrUnknownRelation(name string) error {
+	return fmt.Errorf("unknown relation %s", name)
+}
+
+func (h *handler) registerRelations() error {
+	foreignKeys, err := h.db.Query(
+		`SELECT TABLE_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY TABLE_NAME, ORDINAL_POSITION;`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for foreignKeys.Next() {
+		var tableName, columnName, refTableName, refColumnName string
+		err := foreignKeys.Scan(&tableName, &columnName, &refTableName, &refColumnName)
+		if err != nil {
+			foreignKeys.Close()
+			return err
+		}
+
+		child, ok := h.tables[tableName]
+		if !ok {
+			continue
+		}
+		parent, ok := h.tables[refTableName]
+		if !ok {
+			continue
+		}
+		// records don't carry hidden keys, so such relations can't be followed
+		if _, ok := readableColumn(child, columnName); !ok {
+			continue
+		}
+		if _, ok := readableColumn(parent, refColumnName); !ok {
+			continue
+		}
+
+		// items.author_id -> users.user_id is exposed as items.author
+		// and as users.items in the opposite direction
+		name := strings.TrimSuffix(columnName, "_id")
+		if name == columnName || hasColumn(child, name) {
+			name = refTableName
+		}
+		addRelation(child, relation{
+			Name:      name,
+			Table:     refTableName,
+			Column:    columnName,
+			RefColumn: refColumnName,
+		})
+
+		name = tableName
+		if _, ok := parent.Relations[name]; ok || hasColumn(parent, name) {
+			name = tableName + "_" + columnName
+		}
+		addRelation(parent, relation{
+			Name:      name,
+			Table:     tableName,
+			Column:    refColumnName,
+			RefColumn: columnName,
+			Many:      true,
+		})
+	}
+
+	if err := foreignKeys.Err(); err != nil {
+		foreignKeys.Close()
+		return err
+	}
+
+	return foreignKeys.Close()
+}
+
+func addRelation(t table, rel relation) {
+	if _, ok := t.Relations[rel.Name]; ok {
+		return
+	}
+	t.Relations[rel.Name] = rel
+}
+
+func hasColumn(t table, name string) bool {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func expandNames(table table, expand []string) ([]string, error) {
+	names := []string{}
+	for _, param := range expand {
+		for _, name := range strings.Split(param, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, ok := table.Relations[name]; !ok {
+				return nil, ErrUnknownRelation(name)
+			}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// expandRecords inlines related rows into records, one query per relation
+func (h *handler) expandRecords(r *http.Request, table table, records []map[string]any, names []string) error {
+	for _, name := range names {
+		rel := table.Relations[name]
+		target := h.tables[rel.Table]
+
+		keys := []any{}
+		seen := map[string]bool{}
+		for _, record := range records {
+			key := record[rel.Column]
+			if key == nil || seen[fmt.Sprint(key)] {
+				continue
+			}
+			seen[fmt.Sprint(key)] = true
+			keys = append(keys, key)
+		}
+
+		related := map[string][]map[string]any{}
+		if len(keys) > 0 {
+			conditions := append(h.scopeConditions(r, target), condition{
+				SQL:  fmt.Sprintf("%s IN (%s)", rel.RefColumn, placeholders(len(keys))),
+				Args: keys,
+			})
+			where, args := whereClause(conditions)
+			// many-side rows come in the order a child listing would give
+			orderBy := ""
+			if target.PrimaryKeyName != "" {
+				orderBy = " ORDER BY " + target.PrimaryKeyName
+			}
+
+			rows, err := h.reader(r).Query(
+				fmt.Sprintf("SELECT * FROM %s%s%s;", rel.Table, where, orderBy), args...,
+			)
+			if err != nil {
+				return err
+			}
+
+			relatedRecords, err := scanRecords(rows, target)
+			if err != nil {
+				return err
+			}
+
+			for _, record := range relatedRecords {
+				key := fmt.Sprint(record[rel.RefColumn])
+				related[key] = append(related[key], record)
+			}
+			h.maskRecords(r, target, relatedRecords)
+		}
+
+		for _, record := range records {
+			var matches []map[string]any
+			if key := record[rel.Column]; key != nil {
+				matches = related[fmt.Sprint(key)]
+			}
+
+			switch {
+			case rel.Many && matches == nil:
+				record[name] = []map[string]any{}
+			case rel.Many:
+				record[name] = matches
+			case len(matches) == 0:
+				record[name] = nil
+			default:
+				record[name] = matches[0]
+			}
+		}
+	}
+
+	return nil
+}
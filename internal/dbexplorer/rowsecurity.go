@@ -0,0 +1,230 @@
+package dbexplorer
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// rowFilter is a compiled predicate template like
+// "tenant_id = {claims.tenant} AND region IN ({claims.region}, 'global')"
+type rowFilter struct {
+	SQL    string
+	Claims []string
+	// Fixed maps columns compared for equality with a claim to that claim,
+	// inserts get the claim's value and updates can't change it
+	Fixed map[string]string
+}
+
+var (
+	claimPlaceholder = regexp.MustCompile(`\{claims\.([\w.]+)\}`)
+	fixedColumn      = regexp.MustCompile(`(?:^|[\s(])(\w+)\s*=\s*\{claims\.([\w.]+)\}`)
+)
+
+func ErrRowFilterField(colName string) error {
+	return fmt.Errorf("field %s doesn't match your row filter", colName)
+}
+
+func ErrMissingClaim(claim string) error {
+	return fmt.Errorf("claim %s is required", claim)
+}
+
+func compileRowFilter(t table, template string) (*rowFilter, error) {
+	f := &rowFilter{Fixed: map[string]string{}}
+	f.SQL = claimPlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		f.Claims = append(f.Claims, claimPlaceholder.FindStringSubmatch(m)[1])
+		return "?"
+	})
+
+	// only plain conjunctions can be injected on insert, with an OR the
+	// column may legitimately hold other values; rowsVisible still checks
+	// every written row against the whole filter
+	if !strings.Contains(strings.ToUpper(template), " OR ") {
+		for _, m := range fixedColumn.FindAllStringSubmatch(template, -1) {
+			if !hasColumn(t, m[1]) {
+				return nil, ErrUnknownColumn(t.Name, m[1])
+			}
+			f.Fixed[m[1]] = m[2]
+		}
+	}
+	return f, nil
+}
+
+// claimValue follows a dotted path such as "org.id" through the claims
+func claimValue(claims map[string]any, path string) (any, bool) {
+	var val any = claims
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := val.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if val, ok = obj[key]; !ok || val == nil {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// condition binds the caller's claims; a caller missing one of them
+// matches no rows at all
+func (f *rowFilter) condition(claims map[string]any) condition {
+	c := condition{SQL: f.SQL}
+	for _, claim := range f.Claims {
+		val, ok := claimValue(claims, claim)
+		if !ok {
+			return condition{SQL: "1 = 0"}
+		}
+		c.Args = append(c.Args, val)
+	}
+	return c
+}
+
+// fixedValues returns the values row security imposes on written rows,
+// claims are passed as they are and left for the database to convert
+func (f *rowFilter) fixedValues(claims map[string]any) (map[string]any, error) {
+	values := map[string]any{}
+	for colName, claim := range f.Fixed {
+		val, ok := claimValue(claims, claim)
+		if !ok {
+			return nil, ErrMissingClaim(claim)
+		}
+		values[colName] = val
+	}
+	return values, nil
+}
+
+// hasAdminRole lifts row filters and masking; unlike isAdmin
+// it doesn't follow from having no access control at all
+func (h *handler) hasAdminRole(r *http.Request) bool {
+	principal, ok := PrincipalFromContext(r.Context())
+	return ok && slices.Contains(principal.Roles, h.adminRole)
+}
+
+func requestClaims(r *http.Request) map[string]any {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		return principal.Claims
+	}
+	return nil
+}
+
+// rowSecurityConditions restricts reads and writes to the caller's rows
+func (h *handler) rowSecurityConditions(r *http.Request, table table) []condition {
+	if table.RowFilter == nil || h.hasAdminRole(r) {
+		return nil
+	}
+	return []condition{table.RowFilter.condition(requestClaims(r))}
+}
+
+// checkFixedValues makes sure a write body doesn't move a row out of the
+// caller's reach and returns the values to use for the filtered columns
+func checkFixedValues(table table, requestBody map[string]any, opts writeOptions) (map[string]any, error) {
+	if table.RowFilter == nil || opts.BypassRowFilters {
+		return nil, nil
+	}
+
+	fixed, err := table.RowFilter.fixedValues(opts.Claims)
+	if err != nil {
+		return nil, err
+	}
+	for name, val := range fixed {
+		// a JSON 42 and a "42" claim are the same tenant
+		if bodyVal, ok := requestBody[name]; ok && scalarString(bodyVal) != scalarString(val) {
+			return nil, ErrRowFilterField(name)
+		}
+	}
+	return fixed, nil
+}
+
+// scalarString formats claims and keys, JSON numbers without an
+// exponent: fmt.Sprint would print 1234567 as 1.234567e+06
+func scalarString(val any) string {
+	if f, ok := val.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(val)
+}
+
+// rowsVisible reads written rows back inside the write's transaction and
+// reports whether the caller's row filter still lets all of them through.
+// Fixed columns only cover plain equalities, a filter such as
+// "tenant_id = {claims.tenant} OR public = 1" is enforced here; callers
+// answer 403 and roll back when it returns false. Tables without a primary
+// key can't be checked this way and rely on the fixed columns alone.
+func (h *handler) rowsVisible(q querier, r *http.Request, table table, keys []any) (bool, error) {
+	conditions := h.rowSecurityConditions(r, table)
+	if len(conditions) == 0 || table.PrimaryKeyName == "" {
+		return true, nil
+	}
+
+	seen := map[string]bool{}
+	var unique []any
+	for _, key := range keys {
+		if key == nil || seen[scalarString(key)] {
+			continue
+		}
+		seen[scalarString(key)] = true
+		unique = append(unique, key)
+	}
+	if len(unique) == 0 {
+		return true, nil
+	}
+
+	where, args := whereClause(append(conditions, condition{
+		SQL:  fmt.Sprintf("%s IN (%s)", table.PrimaryKeyName, placeholders(len(unique))),
+		Args: unique,
+	}))
+	var count int
+	err := q.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s%s;", table.Name, where), args...).Scan(&count)
+	return count == len(unique), err
+}
+
+// rowOutOfReach locks the row with key for the rest of the transaction and
+// reports whether it exists but the caller's row filter keeps it out of
+// reach; a missing row is in reach, the caller may create it
+func (h *handler) rowOutOfReach(q querier, r *http.Request, table table, key any) (bool, error) {
+	conditions := h.rowSecurityConditions(r, table)
+	if len(conditions) == 0 {
+		return false, nil
+	}
+
+	where, args := whereClause(conditions)
+	var visible sql.NullBool
+	err := q.QueryRow(
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? FOR UPDATE;",
+			strings.TrimPrefix(where, " WHERE "), table.Name, table.PrimaryKeyName,
+		), append(args, key)...,
+	).Scan(&visible)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return !visible.Bool, err
+}
+
+// recordMatches evaluates conditions, such as the caller's row filter,
+// against a record that may no longer be in the table, e.g. a version from
+// the audit log, by selecting its values as a derived table named after
+// the table
+func (h *handler) recordMatches(r *http.Request, table table, record map[string]any, conditions []condition) (bool, error) {
+	if len(conditions) == 0 {
+		return true, nil
+	}
+
+	columns := make([]string, 0, len(table.Columns))
+	args := make([]any, 0, len(table.Columns))
+	for _, col := range table.Columns {
+		columns = append(columns, "? AS "+col.Name)
+		args = append(args, record[col.Name])
+	}
+
+	where, whereArgs := whereClause(conditions)
+	var count int
+	err := h.reader(r).QueryRow(
+		fmt.Sprintf("SELECT COUNT(*) FROM (SELECT %s) AS %s%s;", strings.Join(columns, ", "), table.Name, where),
+		append(args, whereArgs...)...,
+	).Scan(&count)
+	return count > 0, err
+}
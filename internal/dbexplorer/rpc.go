@@ -0,0 +1,338 @@
+package dbexplorer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type routine struct {
+	Name       string
+	IsFunction bool
+	// ReadsOnly is set for routines declared NO SQL or READS SQL DATA
+	ReadsOnly  bool
+	ReturnType columnType
+	Params     []routineParam
+}
+
+// routineQuerier is where a routine call runs: the pool, a connection
+// or a transaction on one
+type routineQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type routineParam struct {
+	column
+	Mode string
+}
+
+func (h *handler) registerRoutines() error {
+	routines, err := h.db.Query(
+		`SELECT ROUTINE_NAME, ROUTINE_TYPE, SQL_DATA_ACCESS
+		FROM INFORMATION_SCHEMA.ROUTINES
+		WHERE ROUTINE_SCHEMA = DATABASE();`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for routines.Next() {
+		var name, routineType, dataAccess string
+		if err := routines.Scan(&name, &routineType, &dataAccess); err != nil {
+			routines.Close()
+			return err
+		}
+		h.routines[name] = &routine{
+			Name:       name,
+			IsFunction: routineType == "FUNCTION",
+			ReadsOnly:  dataAccess == "NO SQL" || dataAccess == "READS SQL DATA",
+		}
+	}
+
+	if err := routines.Err(); err != nil {
+		routines.Close()
+		return err
+	}
+
+	if err := routines.Close(); err != nil {
+		return err
+	}
+
+	params, err := h.db.Query(
+		`SELECT SPECIFIC_NAME, ORDINAL_POSITION, PARAMETER_MODE, PARAMETER_NAME, DATA_TYPE
+		FROM INFORMATION_SCHEMA.PARAMETERS
+		WHERE SPECIFIC_SCHEMA = DATABASE()
+		ORDER BY SPECIFIC_NAME, ORDINAL_POSITION;`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for params.Next() {
+		var routineName, dataType string
+		var position int
+		var mode, name sql.NullString
+		err := params.Scan(&routineName, &position, &mode, &name, &dataType)
+		if err != nil {
+			params.Close()
+			return err
+		}
+
+		rt, ok := h.routines[routineName]
+		if !ok {
+			continue
+		}
+
+		// position 0 describes the return value of a function
+		if position == 0 {
+			rt.ReturnType = getType(dataType)
+			continue
+		}
+
+		rt.Params = append(rt.Params, routineParam{
+			column: column{
+				Name:       name.String,
+				Type:       getType(dataType),
+				IsNullable: true,
+			},
+			Mode: mode.String,
+		})
+	}
+
+	if err := params.Err(); err != nil {
+		params.Close()
+		return err
+	}
+
+	return params.Close()
+}
+
+func (h *handler) callRoutine(w http.ResponseWriter, r *http.Request) {
+	rt, ok := h.routines[r.PathValue("procedure")]
+	if !ok {
+		http.Error(w, `{"error": "unknown procedure"}`, http.StatusNotFound)
+		return
+	}
+	if !h.allowed(r, rt.Name, OpCall) {
+		forbidden(w, ErrForbidden(OpCall, rt.Name))
+		return
+	}
+	// functions can modify data as well as procedures, only a routine
+	// declared not to is let through while writes are off, and since
+	// MySQL doesn't enforce the declaration it runs in a read-only
+	// transaction then
+	readOnly := h.writesOff()
+	if !rt.ReadsOnly && h.rejectWrite(w, r) {
+		return
+	}
+
+	var requestBody map[string]any
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		badRequest(w, err)
+		return
+	}
+
+	var values []any
+	for _, param := range rt.Params {
+		if param.Mode == "OUT" {
+			if _, ok := requestBody[param.Name]; ok {
+				badRequest(w, ErrTypeMismatch(param.Name))
+				return
+			}
+			values = append(values, nil)
+			continue
+		}
+
+		val, err := validateColumnType(param.column, requestBody[param.Name])
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		values = append(values, val)
+	}
+
+	var response map[string]any
+	if rt.IsFunction {
+		response, err = h.callFunction(r, rt, values, readOnly)
+	} else {
+		response, err = h.callProcedure(r, rt, values, readOnly)
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{response},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
+
+func (h *handler) callFunction(r *http.Request, rt *routine, values []any, readOnly bool) (map[string]any, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+
+	var q routineQuerier = h.db
+	if readOnly {
+		tx, err := h.db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	var raw []byte
+	err := q.QueryRowContext(r.Context(),
+		fmt.Sprintf("SELECT %s(%s);", rt.Name, placeholders), values...,
+	).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"result": convertValue(raw, rt.ReturnType)}, nil
+}
+
+// callProcedure passes OUT and INOUT params through session variables,
+// so the whole call has to stay on a single connection
+func (h *handler) callProcedure(r *http.Request, rt *routine, values []any, readOnly bool) (map[string]any, error) {
+	c, err := h.db.Conn(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var conn routineQuerier = c
+	if readOnly {
+		tx, err := c.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		conn = tx
+	}
+
+	var args []any
+	var placeholders []string
+	var outParams []routineParam
+	var outVariables []string
+
+	for i, param := range rt.Params {
+		if param.Mode == "IN" {
+			args = append(args, values[i])
+			placeholders = append(placeholders, "?")
+			continue
+		}
+
+		variable := "@_rpc_" + param.Name
+		if param.Mode == "INOUT" {
+			_, err := conn.ExecContext(r.Context(), fmt.Sprintf("SET %s = ?;", variable), values[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		placeholders = append(placeholders, variable)
+		outParams = append(outParams, param)
+		outVariables = append(outVariables, variable)
+	}
+
+	rows, err := conn.QueryContext(r.Context(),
+		fmt.Sprintf("CALL %s(%s);", rt.Name, strings.Join(placeholders, ",")), args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resultSets := [][]map[string]any{}
+	for {
+		records, err := scanResultSet(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if records != nil {
+			resultSets = append(resultSets, records)
+		}
+
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	out := map[string]any{}
+	if len(outParams) > 0 {
+		raw := make([]any, len(outParams))
+		for i := range raw {
+			raw[i] = new([]byte)
+		}
+
+		err := conn.QueryRowContext(r.Context(),
+			fmt.Sprintf("SELECT %s;", strings.Join(outVariables, ",")),
+		).Scan(raw...)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, param := range outParams {
+			out[param.Name] = convertValue(*raw[i].(*[]byte), param.Type)
+		}
+	}
+
+	return map[string]any{
+		"result_sets": resultSets,
+		"out":         out,
+	}, nil
+}
+
+// scanResultSet reads the current result set, typing values by the
+// column types reported by the server since it may not map to any table.
+// The status packet that ends every CALL has no columns and yields nil.
+func scanResultSet(rows *sql.Rows) ([]map[string]any, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	if len(columnTypes) == 0 {
+		return nil, nil
+	}
+
+	records := []map[string]any{}
+	for rows.Next() {
+		values := make([]any, len(columnTypes))
+		for i := range values {
+			values[i] = new([]byte)
+		}
+
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+
+		record := make(map[string]any, len(columnTypes))
+		for i, ct := range columnTypes {
+			raw := *values[i].(*[]byte)
+			record[ct.Name()] = convertValue(raw, getType(ct.DatabaseTypeName()))
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,125 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+func (t table) softDeleteColumn() (column, bool) {
+	for _, col := range t.Columns {
+		if t.SoftDelete != "" && col.Name == t.SoftDelete {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// flag-like columns mark deleted rows with 1, anything else
+// (typically deleted_at) with the time of deletion
+func softDeleteValue(col column) string {
+	if col.Type == TYPEINT || col.Type == TYPEBOOL {
+		return "1"
+	}
+	return "CURRENT_TIMESTAMP"
+}
+
+func activeCondition(col column) condition {
+	if col.Type == TYPEINT || col.Type == TYPEBOOL {
+		return condition{SQL: fmt.Sprintf("%[1]s IS NULL OR %[1]s = 0", col.Name)}
+	}
+	return condition{SQL: col.Name + " IS NULL"}
+}
+
+// scopeConditions restricts every read and write on the table to the rows
+// the request may see: those passing the table's row filter, and unless an
+// admin asks for ?include_deleted=true, those not soft-deleted
+func (h *handler) scopeConditions(r *http.Request, table table) []condition {
+	conditions := []condition{}
+
+	if col, ok := table.softDeleteColumn(); ok {
+		includeDeleted, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted"))
+		if !includeDeleted || !h.isAdmin(r) {
+			conditions = append(conditions, activeCondition(col))
+		}
+	}
+
+	return append(conditions, h.rowSecurityConditions(r, table)...)
+}
+
+func (h *handler) restoreRow(w http.ResponseWriter, r *http.Request) {
+	tableName := r.Context().Value(TABLE).(string)
+	rowID := r.PathValue("rowID")
+	table := h.tables[tableName]
+
+	col, ok := table.softDeleteColumn()
+	if !ok {
+		http.Error(w, `{"error": "table has no soft delete"}`, http.StatusNotFound)
+		return
+	}
+
+	restoredValue := "NULL"
+	if col.Type == TYPEINT || col.Type == TYPEBOOL {
+		restoredValue = "0"
+	}
+
+	conditions := append(h.rowSecurityConditions(r, table),
+		primaryKeyCondition(table, rowID),
+		condition{SQL: "NOT (" + activeCondition(col).SQL + ")"},
+	)
+	where, args := whereClause(conditions)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	audit := h.newAuditLog()
+	var before map[string]any
+	if audit != nil {
+		if before, err = snapshot(tx, table, rowID); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	result, err := tx.Exec(
+		fmt.Sprintf("UPDATE %s SET %s = %s%s;", tableName, col.Name, restoredValue, where),
+		args...,
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if rowsAffected > 0 {
+		if err := audit.capture(tx, AuditRestore, table, rowID, before); err != nil {
+			internalError(w, err)
+			return
+		}
+	}
+
+	if err := h.commit(r, tx, audit); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{
+			map[string]any{"restored": rowsAffected},
+		},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
@@ -0,0 +1,214 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	upsertCreated = "created"
+	upsertUpdated = "updated"
+)
+
+// upsertStatement builds INSERT ... ON DUPLICATE KEY UPDATE for one row.
+// Only the columns present in the body are overwritten on conflict, and an
+// auto increment key is routed through LAST_INSERT_ID so the existing key
+// is reported back for updated rows too.
+func upsertStatement(table table, requestBody map[string]any, parent *parentKey, opts writeOptions) (string, []any, error) {
+	values, err := insertValues(table, requestBody, parent, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	columnNames := insertColumns(table)
+
+	var updates []string
+	for _, col := range table.Columns {
+		if col.Name == table.PrimaryKeyName {
+			if col.IsAutoIncrement {
+				updates = append(updates, fmt.Sprintf("%[1]s = LAST_INSERT_ID(%[1]s)", col.Name))
+
+				if val, ok := requestBody[col.Name]; ok {
+					val, err := validateColumnType(col, val)
+					if err != nil {
+						return "", nil, err
+					}
+					columnNames = append(columnNames, col.Name)
+					values = append(values, val)
+				}
+			}
+			continue
+		}
+
+		_, ok := requestBody[col.Name]
+		ok = ok && col.writable()
+		if parent != nil && col.Name == parent.Column {
+			ok = true
+		}
+		if ok {
+			updates = append(updates, fmt.Sprintf("%[1]s = VALUES(%[1]s)", col.Name))
+		}
+	}
+
+	if len(updates) == 0 {
+		updates = append(updates, fmt.Sprintf("%[1]s = %[1]s", table.PrimaryKeyName))
+	}
+
+	tuple, args := valuesTuple(values)
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE %s;",
+		table.Name, strings.Join(columnNames, ","), tuple, strings.Join(updates, ","),
+	)
+	return query, args, nil
+}
+
+func (h *handler) upsertRows(w http.ResponseWriter, r *http.Request, table table, requestRows []map[string]any, parent *parentKey, isBulk bool) {
+	opts := h.writeOptions(r)
+	queries := make([]string, 0, len(requestRows))
+	args := make([][]any, 0, len(requestRows))
+	rowErrors := []map[string]any{}
+	for i, requestBody := range requestRows {
+		query, values, err := upsertStatement(table, requestBody, parent, opts)
+		if err != nil {
+			rowErrors = append(rowErrors, map[string]any{"row": i, "error": err.Error()})
+			continue
+		}
+		queries = append(queries, query)
+		args = append(args, values)
+	}
+
+	if len(rowErrors) > 0 && !isBulk {
+		badRequest(w, fmt.Errorf("%s", rowErrors[0]["error"]))
+		return
+	}
+	if len(rowErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		err := json.NewEncoder(w).Encode(
+			map[string]any{"error": "invalid rows", "rows": rowErrors},
+		)
+		if err != nil {
+			internalError(w, err)
+		}
+		return
+	}
+
+	isAutoIncrement := primaryKeyColumn(table).IsAutoIncrement
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	audit := h.newAuditLog()
+	keys := make([]any, 0, len(queries))
+	statuses := make([]string, 0, len(queries))
+	for i, query := range queries {
+		key, hasKey := requestRows[i][table.PrimaryKeyName]
+		if hasKey {
+			// upsertStatement has validated it, this turns a JSON number
+			// into the int64 the representation and audit log look up
+			key, _ = validateColumnType(primaryKeyColumn(table), key)
+		}
+
+		// a body matching the caller's claims would move a conflicting
+		// row of someone else's into their reach, rowsVisible can't tell
+		if hasKey {
+			outOfReach, err := h.rowOutOfReach(tx, r, table, key)
+			if err != nil {
+				internalError(w, err)
+				return
+			}
+			if outOfReach {
+				forbidden(w, ErrForbidden(OpUpdate, table.Name))
+				return
+			}
+		}
+
+		var before map[string]any
+		if hasKey && audit != nil {
+			before, err = snapshot(tx, table, key)
+			if err != nil {
+				internalError(w, err)
+				return
+			}
+		}
+
+		result, err := tx.Exec(query, args[i]...)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		// MySQL reports 1 affected row for an insert and 2 for an update
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		status := upsertUpdated
+		if rowsAffected == 1 {
+			status = upsertCreated
+		}
+
+		if isAutoIncrement && !hasKey {
+			key, err = result.LastInsertId()
+			if err != nil {
+				internalError(w, err)
+				return
+			}
+		}
+
+		// the conflicting row may be out of the caller's reach, or the
+		// written values may put it there; rolling back undoes the write
+		visible, err := h.rowsVisible(tx, r, table, []any{key})
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		if !visible && status == upsertUpdated {
+			forbidden(w, ErrForbidden(OpUpdate, table.Name))
+			return
+		}
+		if !visible {
+			forbidden(w, ErrForbidden(OpCreate, table.Name))
+			return
+		}
+
+		action := AuditUpdate
+		if status == upsertCreated {
+			action = AuditCreate
+		}
+		if err := audit.capture(tx, action, table, key, before); err != nil {
+			internalError(w, err)
+			return
+		}
+
+		keys = append(keys, key)
+		statuses = append(statuses, status)
+	}
+
+	if err := h.commit(r, tx, audit); err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if wantsRepresentation(r) {
+		h.writeRepresentation(w, r, table, keys, isBulk)
+		return
+	}
+
+	response := map[string]any{table.PrimaryKeyName: keys, "status": statuses}
+	if !isBulk {
+		response = map[string]any{table.PrimaryKeyName: keys[0], "status": statuses[0]}
+	}
+
+	err = json.NewEncoder(w).Encode(
+		Response{response},
+	)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"hw6/internal/dbexplorer"
 	"io"
@@ -26,7 +31,10 @@ type Case struct {
 	Query  string
 	Status int
 	Result interface{}
-	Body   interface{}
+	// Body кодируется в JSON, строка уходит как есть, например NDJSON
+	Body interface{}
+	// Headers добавляются к запросу, например Prefer или If-Match
+	Headers map[string]string
 }
 
 var (
@@ -179,6 +187,30 @@ func TestApis(t *testing.T) {
 				},
 			},
 		},
+		Case{
+			Path:  "/items",
+			Query: "updated=is.null",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "id=unknown.1",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "invalid filter for field id",
+			},
+		},
 		Case{
 			Path: "/items/1",
 			Result: CR{
@@ -501,66 +533,2369 @@ func TestApis(t *testing.T) {
 				},
 			},
 		},
-	}
 
-	runCases(t, ts, db, cases)
-}
+		// массовая вставка
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Body: []CR{
+				CR{"title": "bulk 1", "description": ""},
+				CR{"title": "bulk 2", "description": "", "updated": "autotests"},
+			},
+			Result: CR{
+				"response": CR{
+					"id": []int{4, 5},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Status: http.StatusBadRequest,
+			Body: []CR{
+				CR{"title": "bulk 3"},
+				CR{"title": 42},
+			},
+			Result: CR{
+				"error": "invalid rows",
+				"rows": []CR{
+					CR{"row": 1, "error": "field title have invalid type"},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Body:   []CR{},
+			Result: CR{
+				"response": CR{
+					"id": []int{},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Headers: map[string]string{
+				"Content-Type": "application/x-ndjson",
+			},
+			Body: `{"title": "ndjson 1", "description": ""}
+{"title": "ndjson 2", "description": "second"}
+`,
+			Result: CR{
+				"response": CR{
+					"id": []int{6, 7},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Status: http.StatusBadRequest,
+			Headers: map[string]string{
+				"Content-Type": "application/x-ndjson",
+			},
+			Body: `{"title": "ndjson 3", "description": ""}
+{"title": 
+`,
+			Result: CR{
+				"error": "unexpected EOF",
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "title=like.ndjson%25",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 6, "title": "ndjson 1", "description": "", "updated": nil},
+						CR{"id": 7, "title": "ndjson 2", "description": "second", "updated": nil},
+					},
+				},
+			},
+		},
 
-func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
-	for idx, item := range cases {
-		var (
-			result   interface{}
-			expected interface{}
-			req      *http.Request
-		)
+		// удаление по фильтру
+		Case{
+			Path:   "/items",
+			Method: http.MethodDelete,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "filter is required",
+			},
+		},
+		Case{
+			Path:   "/items?updated=eq.autotests&titel=eq.nomatch&dry_run=true",
+			Method: http.MethodDelete,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown parameter titel",
+			},
+		},
+		Case{
+			Path:   "/items?updated=eq.autotests&titel=eq.nomatch",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"description": "widened",
+			},
+			Result: CR{
+				"error": "unknown parameter titel",
+			},
+		},
+		Case{
+			Path:   "/items?updated=eq.autotests&dry_run=true",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+					"dry_run": true,
+				},
+			},
+		},
 
-		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+		// полная замена записи
+		Case{
+			Path:   "/users/2",
+			Method: http.MethodPut,
+			Body: CR{
+				"login": "replaced",
+				"info":  "full replace",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/users/2",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id":  2,
+						"login":    "replaced",
+						"password": "",
+						"email":    "",
+						"info":     "full replace",
+						"updated":  nil,
+					},
+				},
+			},
+		},
 
-		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
-		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при инициализации в NewDBExplorer
-		if db.Stats().OpenConnections != 1 {
-			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
-		}
+		// обновление без известных полей
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"unkn_field": "love",
+			},
+			Result: CR{
+				"error": "nothing to update",
+			},
+		},
 
-		if item.Method == "" || item.Method == http.MethodGet {
-			var errNewReq error
-			req, errNewReq = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
-			if errNewReq != nil {
-				panic(errNewReq)
-			}
-		} else {
-			data, errMarshal := json.Marshal(item.Body)
-			if errMarshal != nil {
-				panic(errMarshal)
-			}
-			reqBody := bytes.NewReader(data)
-			var errNewReq error
-			req, errNewReq = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
-			if errNewReq != nil {
-				panic(errNewReq)
-			}
-			req.Header.Add("Content-Type", "application/json")
-		}
+		// upsert с большим ключом
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Headers: map[string]string{
+				"Prefer": "resolution=merge-duplicates, return=representation",
+			},
+			Body: CR{
+				"id":          2000000,
+				"title":       "upserted",
+				"description": "big key",
+			},
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          2000000,
+						"title":       "upserted",
+						"description": "big key",
+						"updated":     nil,
+					},
+				},
+			},
+		},
 
-		resp, err := client.Do(req)
-		if err != nil {
-			t.Fatalf("[%s] request error: %v", caseName, err)
-			continue
-		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			t.Fatalf("[%s] error readall: %v", caseName, err)
-			continue
-		}
+		// пакет операций в одной транзакции
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: CR{
+				"operations": []CR{
+					CR{"op": "create", "table": "items", "body": CR{"title": "batch", "description": "ref"}},
+					CR{"op": "update", "table": "items", "id": CR{"$ref": 0}, "body": CR{"updated": "batch"}},
+				},
+			},
+			Result: CR{
+				"response": CR{
+					"results": []CR{
+						CR{"id": 2000001},
+						CR{"updated": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path: "/items/2000001",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          2000001,
+						"title":       "batch",
+						"description": "ref",
+						"updated":     "batch",
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"operations": []CR{
+					CR{"op": "frob", "table": "items", "id": 1},
+				},
+			},
+			Result: CR{
+				"error": `operation 0: unknown op "frob"`,
+			},
+		},
 
-		// fmt.Printf("[%s] body: %s\n", caseName, string(body))
-		if item.Status == 0 {
-			item.Status = http.StatusOK
-		}
+		// оптимистичная блокировка
+		Case{
+			Path:   "/items/2000001",
+			Method: http.MethodPost,
+			Status: http.StatusPreconditionFailed,
+			Headers: map[string]string{
+				"If-Match": `"stale"`,
+			},
+			Body: CR{
+				"updated": "lost update",
+			},
+			Result: CR{
+				"error": "precondition failed",
+			},
+		},
+		Case{
+			Path:   "/items/2000001",
+			Method: http.MethodPost,
+			Headers: map[string]string{
+				"If-Match": recordETag(CR{"id": 2000001, "title": "batch", "description": "ref", "updated": "batch"}),
+			},
+			Body: CR{
+				"updated": "etag",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/items/2000001",
+			Method: http.MethodDelete,
+			Status: http.StatusPreconditionFailed,
+			Headers: map[string]string{
+				"If-Match": recordETag(CR{"id": 2000001, "title": "batch", "description": "ref", "updated": "batch"}),
+			},
+			Result: CR{
+				"error": "precondition failed",
+			},
+		},
 
-		if resp.StatusCode != item.Status {
-			t.Fatalf("[%s] expected http status %v, got %v", caseName, item.Status, resp.StatusCode)
+		// условный GET
+		Case{
+			Path:   "/items/2000001",
+			Status: http.StatusNotModified,
+			Headers: map[string]string{
+				"If-None-Match": recordETag(CR{"id": 2000001, "title": "batch", "description": "ref", "updated": "etag"}),
+			},
+		},
+		Case{
+			Path: "/items/2000001",
+			Headers: map[string]string{
+				"If-None-Match": `"stale"`,
+			},
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          2000001,
+						"title":       "batch",
+						"description": "ref",
+						"updated":     "etag",
+					},
+				},
+			},
+		},
+
+		// режим обслуживания
+		Case{
+			Path:   "/_maintenance",
+			Method: http.MethodPut,
+			Body: CR{
+				"enabled": true,
+				"reason":  "migration",
+			},
+			Result: CR{
+				"response": CR{
+					"enabled": true,
+					"reason":  "migration",
+				},
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Status: http.StatusServiceUnavailable,
+			Body: CR{
+				"info": "paused",
+			},
+			Result: CR{
+				"error":  "writes are paused for maintenance",
+				"reason": "migration",
+			},
+		},
+		Case{
+			Path: "/users/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id":  1,
+						"login":    "rvasily",
+						"password": "love",
+						"email":    "rvasily@example.com",
+						"info":     "try update",
+						"updated":  "now",
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/_maintenance",
+			Method: http.MethodPut,
+			Body: CR{
+				"enabled": false,
+			},
+			Result: CR{
+				"response": CR{
+					"enabled": false,
+					"reason":  "",
+				},
+			},
+		},
+
+		// JSON Patch
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPatch,
+			Headers: map[string]string{
+				"Content-Type": "application/json-patch+json",
+			},
+			Body: []CR{
+				CR{"op": "test", "path": "/info", "value": "try update"},
+				CR{"op": "replace", "path": "/info", "value": "patched"},
+				CR{"op": "remove", "path": "/updated"},
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPatch,
+			Status: http.StatusConflict,
+			Headers: map[string]string{
+				"Content-Type": "application/json-patch+json",
+			},
+			Body: []CR{
+				CR{"op": "test", "path": "/info", "value": "try update"},
+				CR{"op": "replace", "path": "/info", "value": "lost update"},
+			},
+			Result: CR{
+				"error": "test failed for field info",
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPatch,
+			Status: http.StatusBadRequest,
+			Headers: map[string]string{
+				"Content-Type": "application/json-patch+json",
+			},
+			Body: []CR{
+				CR{"op": "move", "path": "/info", "from": "/email"},
+			},
+			Result: CR{
+				"error": "unsupported patch operation move",
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPatch,
+			Status: http.StatusUnsupportedMediaType,
+			Headers: map[string]string{
+				"Content-Type": "text/plain",
+			},
+			Body: CR{
+				"info": "plain",
+			},
+			Result: CR{
+				"error": "unsupported patch format",
+			},
+		},
+		Case{
+			Path: "/users/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id":  1,
+						"login":    "rvasily",
+						"password": "love",
+						"email":    "rvasily@example.com",
+						"info":     "patched",
+						"updated":  nil,
+					},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func PrepareTestSecurity(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS notes;`,
+
+		`CREATE TABLE notes (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  tenant_id int(11) NOT NULL,
+  title varchar(255) NOT NULL,
+  author varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  deleted int(11) NOT NULL DEFAULT 0,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO notes (id, tenant_id, title, author, email, deleted) VALUES
+(1,	1,	'первая',	'Алиса',	'alice@example.com',	0),
+(2,	2,	'вторая',	'Боб',	'bob@example.com',	0);`,
+
+		`DROP TABLE IF EXISTS boards;`,
+
+		`CREATE TABLE boards (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  tenant_id int(11) NOT NULL,
+  name varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO boards (id, tenant_id, name) VALUES
+(1,	2,	'bob board');`,
+	}
+
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func CleanupTestSecurity(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS notes;`,
+		`DROP TABLE IF EXISTS boards;`,
+		`DROP TABLE IF EXISTS notes_audit;`,
+	}
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+const jwtSecret = "test-secret"
+
+// signToken собирает JWT, alg "none" дает токен без подписи
+func signToken(alg, secret string, claims CR) string {
+	header, _ := json.Marshal(CR{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	if alg == "none" {
+		return signed + "."
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// apiKey выставляет заголовок для одного из ключей TestSecurity
+func apiKey(key string) map[string]string {
+	return map[string]string{"X-API-Key": key}
+}
+
+func TestSecurity(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestSecurity(db)
+	defer CleanupTestSecurity(db)
+
+	keys := map[string]dbexplorer.Principal{
+		"admin-key":  {Subject: "admin", Roles: []string{"admin"}},
+		"alice-key":  {Subject: "alice", Roles: []string{"editor"}, Claims: map[string]any{"tenant": "1"}},
+		"bob-key":    {Subject: "bob", Roles: []string{"editor"}, Claims: map[string]any{"tenant": "2"}},
+		"viewer-key": {Subject: "victor", Roles: []string{"viewer"}, Claims: map[string]any{"tenant": "1"}},
+		"guest-key":  {Subject: "guest"},
+		"big-key":    {Subject: "big", Roles: []string{"editor"}, Claims: map[string]any{"tenant": "1234567"}},
+		"writer-key": {Subject: "wendy", Roles: []string{"writer"}, Claims: map[string]any{"tenant": "1"}},
+	}
+
+	jwtAuth, err := dbexplorer.NewJWTAuthenticator(dbexplorer.JWTConfig{
+		Secret: []byte(jwtSecret),
+		Issuer: "dbexplorer-test",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	audit, err := dbexplorer.NewTableAuditSink(db, "notes_audit")
+	if err != nil {
+		panic(err)
+	}
+
+	handler, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("notes", "boards"),
+		dbexplorer.WithAudit(audit),
+		dbexplorer.WithCacheControl("public, max-age=60"),
+		dbexplorer.WithRowFilter("notes", "tenant_id = {claims.tenant}"),
+		dbexplorer.WithRowFilter("boards", "tenant_id = {claims.tenant} OR tenant_id = 0"),
+		dbexplorer.WithMasking("notes", "author", dbexplorer.MaskPartial, "editor"),
+		dbexplorer.WithMasking("notes", "email", dbexplorer.MaskPartial, "editor"),
+		dbexplorer.WithAuthenticator(dbexplorer.NewAPIKeyAuthenticator(keys), jwtAuth),
+		dbexplorer.WithSoftDelete("notes", "deleted"),
+		dbexplorer.WithPolicies(
+			dbexplorer.Policy{
+				Role:       "editor",
+				Tables:     []string{"notes", "boards"},
+				Operations: []dbexplorer.Operation{dbexplorer.OpRead, dbexplorer.OpCreate, dbexplorer.OpUpdate, dbexplorer.OpDelete},
+			},
+			dbexplorer.Policy{
+				Role:       "viewer",
+				Tables:     []string{"no*"},
+				Operations: []dbexplorer.Operation{dbexplorer.OpRead},
+			},
+			dbexplorer.Policy{
+				Role:       "writer",
+				Tables:     []string{"notes"},
+				Operations: []dbexplorer.Operation{dbexplorer.OpRead, dbexplorer.OpUpdate},
+			},
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	now := time.Now().Unix()
+	adminClaims := CR{"sub": "carol", "roles": []string{"admin"}, "iss": "dbexplorer-test", "exp": now + 3600}
+	unauthorized := CR{"error": "unauthorized"}
+
+	cases := []Case{
+		// аутентификация
+		Case{
+			Path:   "/notes/1",
+			Status: http.StatusUnauthorized,
+			Result: unauthorized,
+		},
+		Case{
+			Path:    "/notes/1",
+			Status:  http.StatusUnauthorized,
+			Headers: apiKey("wrong-key"),
+			Result:  unauthorized,
+		},
+		Case{
+			Path:    "/notes/1",
+			Headers: bearer(signToken("HS256", jwtSecret, adminClaims)),
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        1,
+						"tenant_id": 1,
+						"title":     "первая",
+						"author":    "Алиса",
+						"email":     "alice@example.com",
+						"deleted":   0,
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/1",
+			Status:  http.StatusUnauthorized,
+			Headers: bearer(signToken("HS256", "other-secret", adminClaims)),
+			Result:  unauthorized,
+		},
+		Case{
+			Path:    "/notes/1",
+			Status:  http.StatusUnauthorized,
+			Headers: bearer(signToken("none", "", adminClaims)),
+			Result:  unauthorized,
+		},
+		Case{
+			Path:   "/notes/1",
+			Status: http.StatusUnauthorized,
+			Headers: bearer(signToken("HS256", jwtSecret,
+				CR{"sub": "carol", "roles": []string{"admin"}, "iss": "dbexplorer-test", "exp": now - 60},
+			)),
+			Result: unauthorized,
+		},
+		Case{
+			Path:   "/notes/1",
+			Status: http.StatusUnauthorized,
+			Headers: bearer(signToken("HS256", jwtSecret,
+				CR{"sub": "carol", "roles": []string{"admin"}, "iss": "someone-else", "exp": now + 3600},
+			)),
+			Result: unauthorized,
+		},
+
+		// политики доступа
+		Case{
+			Path:    "/",
+			Headers: apiKey("guest-key"),
+			Result: CR{
+				"response": CR{
+					"tables": []string{},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes",
+			Status:  http.StatusForbidden,
+			Headers: apiKey("guest-key"),
+			Result: CR{
+				"error": "not allowed to read notes",
+			},
+		},
+		Case{
+			Path:    "/",
+			Headers: apiKey("viewer-key"),
+			Result: CR{
+				"response": CR{
+					"tables": []string{"notes"},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/1",
+			Method:  http.MethodPost,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("viewer-key"),
+			Body: CR{
+				"title": "changed by viewer",
+			},
+			Result: CR{
+				"error": "not allowed to update notes",
+			},
+		},
+		Case{
+			Path:    "/notes/1",
+			Method:  http.MethodDelete,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("viewer-key"),
+			Result: CR{
+				"error": "not allowed to delete notes",
+			},
+		},
+		Case{
+			Path:    "/_batch",
+			Method:  http.MethodPost,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("viewer-key"),
+			Body: CR{
+				"operations": []CR{
+					CR{"op": "delete", "table": "notes", "id": 1},
+				},
+			},
+			Result: CR{
+				"error": "operation 0: not allowed to delete notes",
+			},
+		},
+
+		// мягкое удаление и восстановление только для админа
+		Case{
+			Path:    "/notes/2",
+			Method:  http.MethodDelete,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Status:  http.StatusNotFound,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Query:   "include_deleted=true",
+			Status:  http.StatusNotFound,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/notes/2/_restore",
+			Method:  http.MethodPost,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"error": "admin role required",
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Query:   "include_deleted=true",
+			Headers: apiKey("admin-key"),
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        2,
+						"tenant_id": 2,
+						"title":     "вторая",
+						"author":    "Боб",
+						"email":     "bob@example.com",
+						"deleted":   1,
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/2/_restore",
+			Method:  http.MethodPost,
+			Headers: apiKey("admin-key"),
+			Result: CR{
+				"response": CR{
+					"restored": 1,
+				},
+			},
+		},
+
+		// изоляция арендаторов
+		Case{
+			Path:   "/notes/",
+			Method: http.MethodPut,
+			Status: http.StatusForbidden,
+			Headers: map[string]string{
+				"X-API-Key": "alice-key",
+				"Prefer":    "resolution=merge-duplicates",
+			},
+			Body: CR{
+				"id":        2,
+				"tenant_id": 1,
+				"title":     "stolen",
+			},
+			Result: CR{
+				"error": "not allowed to update notes",
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        2,
+						"tenant_id": 2,
+						"title":     "вторая",
+						"author":    "Боб",
+						"email":     "bob@example.com",
+						"deleted":   0,
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes",
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":        1,
+							"tenant_id": 1,
+							"title":     "первая",
+							"author":    "Алиса",
+							"email":     "alice@example.com",
+							"deleted":   0,
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Status:  http.StatusNotFound,
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Method:  http.MethodPost,
+			Status:  http.StatusNotFound,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"title": "taken over",
+			},
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/notes/",
+			Method:  http.MethodPut,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"title":  "третья",
+				"author": "Алиса",
+				"email":  "alice@example.com",
+			},
+			Result: CR{
+				"response": CR{
+					"id": 3,
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/3",
+			Headers: apiKey("admin-key"),
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        3,
+						"tenant_id": 1,
+						"title":     "третья",
+						"author":    "Алиса",
+						"email":     "alice@example.com",
+						"deleted":   0,
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/",
+			Method:  http.MethodPut,
+			Status:  http.StatusBadRequest,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"tenant_id": 2,
+				"title":     "чужая",
+				"author":    "Алиса",
+				"email":     "alice@example.com",
+			},
+			Result: CR{
+				"error": "field tenant_id doesn't match your row filter",
+			},
+		},
+		Case{
+			Path:    "/notes/1",
+			Method:  http.MethodPost,
+			Status:  http.StatusBadRequest,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"tenant_id": 2,
+			},
+			Result: CR{
+				"error": "field tenant_id doesn't match your row filter",
+			},
+		},
+		Case{
+			Path:    "/notes/",
+			Method:  http.MethodPut,
+			Headers: apiKey("big-key"),
+			Body: CR{
+				"tenant_id": 1234567,
+				"title":     "big tenant",
+				"author":    "Big",
+				"email":     "big@example.com",
+			},
+			Result: CR{
+				"response": CR{
+					"id": 4,
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/",
+			Method:  http.MethodPut,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"tenant_id": 2,
+				"name":      "foreign",
+			},
+			Result: CR{
+				"error": "not allowed to create boards",
+			},
+		},
+		Case{
+			Path:    "/boards/",
+			Method:  http.MethodPut,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"tenant_id": 0,
+				"name":      "shared",
+			},
+			Result: CR{
+				"response": CR{
+					"id": 2,
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/2",
+			Method:  http.MethodPost,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"tenant_id": 2,
+			},
+			Result: CR{
+				"error": "not allowed to update boards",
+			},
+		},
+		Case{
+			Path:    "/_batch",
+			Method:  http.MethodPost,
+			Status:  http.StatusForbidden,
+			Headers: apiKey("alice-key"),
+			Body: CR{
+				"operations": []CR{
+					CR{"op": "create", "table": "boards", "body": CR{"tenant_id": 0, "name": "ok"}},
+					CR{"op": "update", "table": "boards", "id": CR{"$ref": 0}, "body": CR{"tenant_id": 2}},
+				},
+			},
+			Result: CR{
+				"error": "operation 1: not allowed to update boards",
+			},
+		},
+		Case{
+			Path:    "/boards",
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "tenant_id": 2, "name": "bob board"},
+						CR{"id": 2, "tenant_id": 0, "name": "shared"},
+					},
+				},
+			},
+		},
+
+		// маскирование колонок
+		Case{
+			Path:    "/notes/1",
+			Headers: apiKey("viewer-key"),
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        1,
+						"tenant_id": 1,
+						"title":     "первая",
+						"author":    "А***",
+						"email":     "a***@example.com",
+						"deleted":   0,
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/notes",
+			Query:   "email=alice@example.com",
+			Status:  http.StatusBadRequest,
+			Headers: apiKey("viewer-key"),
+			Result: CR{
+				"error": "field email is masked",
+			},
+		},
+		// test по маске позволил бы подбирать значение
+		Case{
+			Path:    "/notes/1",
+			Method:  http.MethodPatch,
+			Status:  http.StatusBadRequest,
+			Headers: map[string]string{"X-API-Key": "writer-key", "Content-Type": "application/json-patch+json"},
+			Body: []CR{
+				CR{"op": "test", "path": "/email", "value": "alice@example.com"},
+				CR{"op": "replace", "path": "/title", "value": "guessed"},
+			},
+			Result: CR{
+				"error": "field email is masked",
+			},
+		},
+
+		// журнал изменений хранит значения без масок, он только для админа
+		Case{
+			Path:    "/_audit",
+			Status:  http.StatusForbidden,
+			Headers: apiKey("viewer-key"),
+			Result: CR{
+				"error": "admin role required",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// ответ зависит от ключа, общий кэш не должен раздавать его другим
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/notes/1", nil)
+	req.Header.Set("X-API-Key", "alice-key")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if vary := resp.Header.Get("Vary"); vary != "Authorization, X-API-Key" {
+		t.Fatalf("expected Vary: Authorization, X-API-Key, got %q", vary)
+	}
+
+	// создание записи попало в журнал в той же транзакции
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+"/_audit?table=notes&key=3", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var auditLog struct {
+		Response struct {
+			Entries []dbexplorer.AuditEntry `json:"entries"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auditLog); err != nil {
+		t.Fatalf("cant unpack audit log: %v", err)
+	}
+	entries := auditLog.Response.Entries
+	if len(entries) != 1 || entries[0].Action != "create" || entries[0].Principal != "alice" ||
+		entries[0].Before != nil || entries[0].After["email"] != "alice@example.com" {
+		t.Fatalf("unexpected audit entries: %#v", entries)
+	}
+
+	// история и откат удаленной без soft delete записи идут по журналу
+	time.Sleep(time.Second)
+	asOf := time.Now().UTC().Format(time.RFC3339)
+	shared := CR{"id": 2, "tenant_id": 0, "name": "shared"}
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:    "/boards/2",
+			Method:  http.MethodDelete,
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/2",
+			Status:  http.StatusNotFound,
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/boards/2",
+			Query:   "as_of=" + asOf,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"response": CR{
+					"record": shared,
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/1",
+			Query:   "as_of=" + asOf,
+			Status:  http.StatusNotFound,
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/notes/3/_history",
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"response": CR{
+					"versions": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/2/_revert?as_of=" + asOf,
+			Method:  http.MethodPost,
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"response": CR{
+					"id":     2,
+					"status": "created",
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/2",
+			Headers: apiKey("alice-key"),
+			Result: CR{
+				"response": CR{
+					"record": shared,
+				},
+			},
+		},
+	})
+
+	if actions := historyActions(t, ts, "/boards/2/_history", "alice-key"); !reflect.DeepEqual(actions, []string{"create", "delete", "create"}) {
+		t.Fatalf("unexpected history: %v", actions)
+	}
+
+	// as_of не отдает мягко удаленную версию, include_deleted только для админа;
+	// админ уводит доску к alice, последняя правка bob уже не видна
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:    "/notes/2",
+			Method:  http.MethodDelete,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Query:   "include_deleted=true&as_of=" + future,
+			Status:  http.StatusNotFound,
+			Headers: apiKey("bob-key"),
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:    "/notes/2",
+			Query:   "include_deleted=true&as_of=" + future,
+			Headers: apiKey("admin-key"),
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        2,
+						"tenant_id": 2,
+						"title":     "вторая",
+						"author":    "Боб",
+						"email":     "bob@example.com",
+						"deleted":   1,
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/2",
+			Method:  http.MethodPost,
+			Headers: apiKey("admin-key"),
+			Body: CR{
+				"tenant_id": 1,
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:    "/boards/2",
+			Method:  http.MethodPost,
+			Headers: apiKey("admin-key"),
+			Body: CR{
+				"name": "alice only",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+	})
+
+	// limit и offset считают только видимые версии
+	for path, expected := range map[string][]string{
+		"/boards/2/_history?limit=1":          {"update"},
+		"/boards/2/_history?limit=2&offset=1": {"create", "delete"},
+	} {
+		if actions := historyActions(t, ts, path, "bob-key"); !reflect.DeepEqual(actions, expected) {
+			t.Fatalf("GET %s: expected %v, got %v", path, expected, actions)
+		}
+	}
+}
+
+// historyActions возвращает действия из истории записи, от новых к старым
+func historyActions(t *testing.T, ts *httptest.Server, path, key string) []string {
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	req.Header.Set("X-API-Key", key)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var history struct {
+		Response struct {
+			Versions []struct {
+				Action string `json:"action"`
+			} `json:"versions"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("cant unpack history: %v", err)
+	}
+	actions := []string{}
+	for _, v := range history.Response.Versions {
+		actions = append(actions, v.Action)
+	}
+	return actions
+}
+
+func PrepareTestRelations(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS posts;`,
+		`DROP TABLE IF EXISTS authors;`,
+
+		`CREATE TABLE authors (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  name varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`CREATE TABLE posts (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  author_id int(11) NOT NULL,
+  title varchar(255) NOT NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT posts_author FOREIGN KEY (author_id) REFERENCES authors (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO authors (id, name) VALUES
+(1,	'rvasily'),
+(2,	'guest');`,
+
+		`INSERT INTO posts (id, author_id, title) VALUES
+(1,	1,	'database/sql'),
+(2,	1,	'memcache'),
+(3,	1,	'grpc');`,
+	}
+
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func CleanupTestRelations(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS posts;`,
+		`DROP TABLE IF EXISTS authors;`,
+	}
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestRelations(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestRelations(db)
+	defer CleanupTestRelations(db)
+
+	handler, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("authors", "posts"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	rvasily := CR{"id": 1, "name": "rvasily"}
+	post1 := CR{"id": 1, "author_id": 1, "title": "database/sql"}
+	post2 := CR{"id": 2, "author_id": 1, "title": "memcache"}
+	post3 := CR{"id": 3, "author_id": 1, "title": "grpc"}
+
+	cases := []Case{
+		// posts.author_id -> authors.id: posts.author и authors.posts
+		Case{
+			Path:  "/posts/1",
+			Query: "expand=author",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":        1,
+						"author_id": 1,
+						"title":     "database/sql",
+						"author":    rvasily,
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/authors",
+			Query: "expand=posts",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "name": "rvasily", "posts": []CR{post1, post2, post3}},
+						CR{"id": 2, "name": "guest", "posts": []CR{}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/posts/1",
+			Query:  "expand=editor",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown relation editor",
+			},
+		},
+		Case{
+			Path:  "/authors/1/posts",
+			Query: "limit=1&offset=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{post2},
+				},
+			},
+		},
+		Case{
+			Path:   "/authors/1/editors",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown relation",
+			},
+		},
+		Case{
+			Path:   "/authors/3/posts",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:   "/authors/2/posts/",
+			Method: http.MethodPut,
+			Body: CR{
+				"title": "first",
+			},
+			Result: CR{
+				"response": CR{
+					"id": 4,
+				},
+			},
+		},
+		Case{
+			Path: "/authors/2/posts",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 4, "author_id": 2, "title": "first"},
+					},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+// PrepareTestRoutines возвращает false, если сервер не поддерживает
+// хранимые процедуры или не показывает их в INFORMATION_SCHEMA.ROUTINES
+func PrepareTestRoutines(db *sql.DB) bool {
+	qs := []string{
+		`DROP TABLE IF EXISTS rpc_items;`,
+
+		`CREATE TABLE rpc_items (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  title varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO rpc_items (id, title) VALUES
+(1,	'apple'),
+(2,	'apricot'),
+(3,	'banana');`,
+
+		`DROP PROCEDURE IF EXISTS rpc_stats;`,
+
+		`CREATE PROCEDURE rpc_stats(IN prefix varchar(255), OUT total int, INOUT counter int)
+BEGIN
+  SELECT COUNT(*) INTO total FROM rpc_items WHERE title LIKE CONCAT(prefix, '%');
+  SET counter = counter + 1;
+  SELECT id, title FROM rpc_items WHERE title LIKE CONCAT(prefix, '%') ORDER BY id;
+  SELECT COUNT(*) AS n FROM rpc_items;
+END`,
+
+		`DROP FUNCTION IF EXISTS rpc_double;`,
+
+		`CREATE FUNCTION rpc_double(x int) RETURNS int DETERMINISTIC NO SQL RETURN x * 2`,
+
+		`DROP PROCEDURE IF EXISTS rpc_sneaky;`,
+
+		// объявление READS SQL DATA MySQL не проверяет
+		`CREATE PROCEDURE rpc_sneaky() READS SQL DATA
+BEGIN
+  INSERT INTO rpc_items (title) VALUES ('sneaky');
+END`,
+	}
+
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			return false
+		}
+	}
+
+	var registered int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM INFORMATION_SCHEMA.ROUTINES
+		WHERE ROUTINE_SCHEMA = DATABASE() AND ROUTINE_NAME IN ('rpc_stats', 'rpc_double', 'rpc_sneaky');`,
+	).Scan(&registered)
+	return err == nil && registered == 3
+}
+
+func CleanupTestRoutines(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS rpc_items;`,
+		`DROP PROCEDURE IF EXISTS rpc_stats;`,
+		`DROP FUNCTION IF EXISTS rpc_double;`,
+		`DROP PROCEDURE IF EXISTS rpc_sneaky;`,
+	}
+	for _, q := range qs {
+		// без поддержки процедур DROP PROCEDURE тоже может не пройти
+		db.Exec(q)
+	}
+}
+
+func TestRoutines(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	defer CleanupTestRoutines(db)
+	if !PrepareTestRoutines(db) {
+		t.Skip("the database server doesn't list stored routines in INFORMATION_SCHEMA.ROUTINES")
+	}
+
+	handler, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("rpc_items"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{
+			Path:   "/_rpc/rpc_stats",
+			Method: http.MethodPost,
+			Body: CR{
+				"prefix":  "ap",
+				"counter": 5,
+			},
+			Result: CR{
+				"response": CR{
+					"result_sets": []interface{}{
+						[]CR{
+							CR{"id": 1, "title": "apple"},
+							CR{"id": 2, "title": "apricot"},
+						},
+						[]CR{
+							CR{"n": 3},
+						},
+					},
+					"out": CR{
+						"total":   2,
+						"counter": 6,
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/_rpc/rpc_stats",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"prefix": 1,
+			},
+			Result: CR{
+				"error": "field prefix have invalid type",
+			},
+		},
+		Case{
+			Path:   "/_rpc/rpc_stats",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"prefix": "ap",
+				"total":  1,
+			},
+			Result: CR{
+				"error": "field total have invalid type",
+			},
+		},
+		Case{
+			Path:   "/_rpc/rpc_double",
+			Method: http.MethodPost,
+			Body: CR{
+				"x": 21,
+			},
+			Result: CR{
+				"response": CR{
+					"result": 42,
+				},
+			},
+		},
+		Case{
+			Path:   "/_rpc/rpc_unknown",
+			Method: http.MethodPost,
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown procedure",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// в режиме только для чтения вызываются лишь NO SQL и READS SQL DATA,
+	// и те в READ ONLY транзакции
+	readOnly, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("rpc_items"),
+		dbexplorer.WithReadOnly(),
+	)
+	if err != nil {
+		panic(err)
+	}
+	ts = httptest.NewServer(readOnly)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path:   "/_rpc/rpc_stats",
+			Method: http.MethodPost,
+			Status: http.StatusMethodNotAllowed,
+			Body: CR{
+				"prefix":  "ap",
+				"counter": 5,
+			},
+			Result: CR{
+				"error": "explorer is read-only",
+			},
+		},
+		Case{
+			Path:   "/_rpc/rpc_double",
+			Method: http.MethodPost,
+			Body: CR{
+				"x": 21,
+			},
+			Result: CR{
+				"response": CR{
+					"result": 42,
+				},
+			},
+		},
+	})
+
+	resp, err := client.Post(ts.URL+"/_rpc/rpc_sneaky", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected the write in rpc_sneaky to fail, got status %d", resp.StatusCode)
+	}
+	var sneaky int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM rpc_items WHERE title = 'sneaky';`).Scan(&sneaky); err != nil {
+		t.Fatalf("cant count rows: %v", err)
+	}
+	if sneaky != 0 {
+		t.Fatalf("rpc_sneaky wrote %d rows while read-only", sneaky)
+	}
+}
+
+func PrepareTestCaching(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS pages;`,
+
+		`CREATE TABLE pages (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  title varchar(255) NOT NULL,
+  updated_at datetime NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO pages (id, title, updated_at) VALUES
+(1,	'first',	'2024-01-01 10:00:00'),
+(2,	'second',	'2024-02-01 10:00:00');`,
+	}
+
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func CleanupTestCaching(db *sql.DB) {
+	_, err := db.Exec(`DROP TABLE IF EXISTS pages;`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestCaching(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestCaching(db)
+	defer CleanupTestCaching(db)
+
+	handler, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("pages"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	// страница на 2024-03-01 не менялась, но список с тех пор изменился
+	since := map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 00:00:00 GMT"}
+	first := CR{"id": 1, "title": "first", "updated_at": "2024-01-01 10:00:00"}
+	second := CR{"id": 2, "title": "second", "updated_at": "2024-02-01 10:00:00"}
+
+	cases := []Case{
+		Case{
+			Path:    "/pages/1",
+			Status:  http.StatusNotModified,
+			Headers: since,
+		},
+		Case{
+			Path: "/pages/1",
+			Headers: map[string]string{
+				"If-Modified-Since": "Sun, 31 Dec 2023 00:00:00 GMT",
+			},
+			Result: CR{
+				"response": CR{
+					"record": first,
+				},
+			},
+		},
+		Case{
+			Path:    "/pages",
+			Headers: since,
+			Result: CR{
+				"response": CR{
+					"records": []CR{first, second},
+				},
+			},
+		},
+		Case{
+			Path:   "/pages/1",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{
+					"deleted": 1,
+				},
+			},
+		},
+		Case{
+			Path:    "/pages",
+			Headers: since,
+			Result: CR{
+				"response": CR{
+					"records": []CR{second},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	resp, err := client.Get(ts.URL + "/pages")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		t.Fatalf("expected no Last-Modified on a collection, got %q", modified)
+	}
+	if resp.Header.Get("ETag") == "" {
+		t.Fatalf("expected an ETag on a collection")
+	}
+
+	resp, err = client.Get(ts.URL + "/pages/2")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if modified := resp.Header.Get("Last-Modified"); modified != "Thu, 01 Feb 2024 10:00:00 GMT" {
+		t.Fatalf("expected Last-Modified of the record, got %q", modified)
+	}
+}
+
+func PrepareTestAccounts(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS accounts;`,
+
+		`CREATE TABLE accounts (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL DEFAULT '',
+  token varchar(255),
+  created varchar(255),
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO accounts (id, login, password, token, created) VALUES
+(1,	'rvasily',	'love',	'secret-token',	'2024');`,
+
+		`DROP TABLE IF EXISTS account_sessions;`,
+
+		`CREATE TABLE account_sessions (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  sid varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+	}
+
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func CleanupTestAccounts(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS accounts;`,
+		`DROP TABLE IF EXISTS account_sessions;`,
+	}
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// accountColumns прячет пароль, отдает created только на чтение
+// и принимает token только на запись
+var accountColumns = []dbexplorer.Option{
+	dbexplorer.WithTables("account*"),
+	dbexplorer.WithExcludeTables("account_sessions"),
+	dbexplorer.WithHiddenColumns("accounts", "password"),
+	dbexplorer.WithReadOnlyColumns("accounts", "created"),
+	dbexplorer.WithWriteOnlyColumns("accounts", "token"),
+}
+
+// trace дописывает name в X-Trace, чтобы проверить порядок middleware
+func trace(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestAccounts(db)
+	defer CleanupTestAccounts(db)
+
+	explorer, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		append(accountColumns, dbexplorer.WithMiddleware(trace("outer"), trace("inner")))...,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	expected := []dbexplorer.TableInfo{
+		{
+			Name:       "accounts",
+			PrimaryKey: "id",
+			Columns: []dbexplorer.ColumnInfo{
+				{Name: "id", Type: "int", AutoIncrement: true},
+				{Name: "login", Type: "string"},
+				{Name: "token", Type: "string", Nullable: true, WriteOnly: true},
+				{Name: "created", Type: "string", Nullable: true, ReadOnly: true},
+			},
+			Relations: []string{},
+		},
+	}
+	if tables := explorer.Tables(); !reflect.DeepEqual(tables, expected) {
+		t.Fatalf("unexpected schema\nGot : %#v\nWant: %#v", tables, expected)
+	}
+	if _, ok := explorer.Table("account_sessions"); ok {
+		t.Fatalf("excluded table account_sessions is described")
+	}
+
+	mux := http.NewServeMux()
+	explorer.Mount(mux, "/db/")
+	ts := httptest.NewServer(mux)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path: "/db/",
+			Result: CR{
+				"response": CR{
+					"tables": []string{"accounts"},
+				},
+			},
+		},
+		Case{
+			Path: "/db/accounts/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":      1,
+						"login":   "rvasily",
+						"created": "2024",
+					},
+				},
+			},
+		},
+	})
+
+	resp, err := client.Get(ts.URL + "/db/accounts")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if trace := resp.Header.Values("X-Trace"); !reflect.DeepEqual(trace, []string{"outer", "inner"}) {
+		t.Fatalf("expected middleware outer then inner, got %v", trace)
+	}
+
+	prefixed, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		append(accountColumns, dbexplorer.WithPathPrefix("/api/"))...,
+	)
+	if err != nil {
+		panic(err)
+	}
+	ts = httptest.NewServer(prefixed)
+
+	for path, status := range map[string]int{
+		"/api/accounts/1": http.StatusOK,
+		"/accounts/1":     http.StatusNotFound,
+	} {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("GET %s: expected status %d, got %d", path, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestColumnAccess(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestAccounts(db)
+	defer CleanupTestAccounts(db)
+
+	handler, err := dbexplorer.NewDBExplorer(db, accountColumns...) //nolint:typecheck
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	strict := map[string]string{"Prefer": "handling=strict"}
+
+	cases := []Case{
+		// исключенная таблица не видна вовсе
+		Case{
+			Path: "/",
+			Result: CR{
+				"response": CR{
+					"tables": []string{"accounts"},
+				},
+			},
+		},
+		Case{
+			Path:   "/account_sessions",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+
+		// скрытые и write-only колонки не отдаются и не фильтруются
+		Case{
+			Path: "/accounts",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "login": "rvasily", "created": "2024"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/accounts",
+			Query:  "password=eq.love",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field password can't be filtered",
+			},
+		},
+		Case{
+			Path:   "/accounts",
+			Query:  "token=eq.secret-token",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field token can't be filtered",
+			},
+		},
+		Case{
+			Path:   "/accounts?password=eq.nomatch&login=eq.rvasily&dry_run=true",
+			Method: http.MethodDelete,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field password can't be filtered",
+			},
+		},
+		Case{
+			Path:   "/accounts?token=eq.nomatch&login=eq.rvasily",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"login": "widened",
+			},
+			Result: CR{
+				"error": "field token can't be filtered",
+			},
+		},
+
+		// запись: read-only игнорируется, в strict режиме отклоняется
+		Case{
+			Path:   "/accounts/1",
+			Method: http.MethodPost,
+			Body: CR{
+				"login":   "vasily",
+				"created": "1999",
+				"token":   "new-token",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:    "/accounts/1",
+			Method:  http.MethodPost,
+			Status:  http.StatusBadRequest,
+			Headers: strict,
+			Body: CR{
+				"created": "1999",
+			},
+			Result: CR{
+				"error": "field created is read-only",
+			},
+		},
+		Case{
+			Path:    "/accounts/",
+			Method:  http.MethodPut,
+			Status:  http.StatusBadRequest,
+			Headers: strict,
+			Body: CR{
+				"login":    "mallory",
+				"password": "guess",
+			},
+			Result: CR{
+				"error": "unknown fields: password",
+			},
+		},
+		Case{
+			Path:    "/accounts/",
+			Method:  http.MethodPut,
+			Headers: strict,
+			Body: CR{
+				"login": "ivan",
+				"token": "ivan-token",
+			},
+			Result: CR{
+				"response": CR{
+					"id": 2,
+				},
+			},
+		},
+		Case{
+			Path: "/accounts",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "login": "vasily", "created": "2024"},
+						CR{"id": 2, "login": "ivan", "created": nil},
+					},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// write-only колонки пишутся, хоть их и не видно
+	var token string
+	if err := db.QueryRow(`SELECT token FROM accounts WHERE id = 1;`).Scan(&token); err != nil {
+		t.Fatalf("cant read token: %v", err)
+	}
+	if token != "new-token" {
+		t.Fatalf("expected the write-only token to be updated, got %q", token)
+	}
+}
+
+func PrepareTestReadOnly(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS ro_items;`,
+
+		`CREATE TABLE ro_items (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  title varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO ro_items (id, title) VALUES
+(1,	'primary');`,
+
+		// реплика - отдельная база с той же таблицей, но другими данными
+		`CREATE DATABASE IF NOT EXISTS golang_replica;`,
+
+		`DROP TABLE IF EXISTS golang_replica.ro_items;`,
+
+		`CREATE TABLE golang_replica.ro_items (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  title varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+
+		`INSERT INTO golang_replica.ro_items (id, title) VALUES
+(1,	'replica');`,
+	}
+
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func CleanupTestReadOnly(db *sql.DB) {
+	qs := []string{
+		`DROP TABLE IF EXISTS ro_items;`,
+		`DROP DATABASE IF EXISTS golang_replica;`,
+	}
+	for _, q := range qs {
+		_, err := db.Exec(q)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	PrepareTestReadOnly(db)
+	defer CleanupTestReadOnly(db)
+
+	handler, err := dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("ro_items"),
+		dbexplorer.WithReadOnly(),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	runCases(t, ts, db, []Case{
+		Case{
+			Path: "/ro_items/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "primary"},
+				},
+			},
+		},
+		Case{
+			Path:   "/ro_items/1",
+			Method: http.MethodPost,
+			Status: http.StatusMethodNotAllowed,
+			Body: CR{
+				"title": "written",
+			},
+			Result: CR{
+				"error": "explorer is read-only",
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Status: http.StatusMethodNotAllowed,
+			Body: CR{
+				"operations": []CR{
+					CR{"op": "delete", "table": "ro_items", "id": 1},
+				},
+			},
+			Result: CR{
+				"error": "explorer is read-only",
+			},
+		},
+	})
+
+	// 405 обязан перечислить разрешенные методы
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/ro_items/1", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if allow := resp.Header.Get("Allow"); resp.StatusCode != http.StatusMethodNotAllowed || allow != "GET, HEAD" {
+		t.Fatalf("expected 405 with Allow: GET, HEAD, got %d with %q", resp.StatusCode, allow)
+	}
+
+	replicaDSN := "root:1234@tcp(localhost:3306)/golang_replica?charset=utf8"
+	replica, err := sql.Open("mysql", replicaDSN)
+	if err != nil {
+		panic(err)
+	}
+	defer replica.Close()
+
+	handler, err = dbexplorer.NewDBExplorer(db, //nolint:typecheck
+		dbexplorer.WithTables("ro_items"),
+		dbexplorer.WithReadReplica(replica),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	ts = httptest.NewServer(handler)
+
+	// чтение идет в реплику, запись и ее проверки - в основную базу
+	runCases(t, ts, db, []Case{
+		Case{
+			Path: "/ro_items/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "replica"},
+				},
+			},
+		},
+		Case{
+			Path:   "/ro_items/1",
+			Method: http.MethodPost,
+			Body: CR{
+				"title": "written",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/ro_items",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "title": "replica"},
+					},
+				},
+			},
+		},
+	})
+
+	var title string
+	if err := db.QueryRow(`SELECT title FROM ro_items WHERE id = 1;`).Scan(&title); err != nil {
+		t.Fatalf("cant read primary: %v", err)
+	}
+	if title != "written" {
+		t.Fatalf("expected the write on the primary, got %q", title)
+	}
+}
+
+// recordETag повторяет ETag, который сервер считает по записи без version column
+func recordETag(record CR) string {
+	data, err := json.Marshal(record)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha1.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
+	for idx, item := range cases {
+		var (
+			result   interface{}
+			expected interface{}
+			req      *http.Request
+		)
+
+		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+
+		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
+		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при инициализации в NewDBExplorer
+		if db.Stats().OpenConnections != 1 {
+			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
+		}
+
+		if item.Method == "" || item.Method == http.MethodGet {
+			var errNewReq error
+			req, errNewReq = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
+			if errNewReq != nil {
+				panic(errNewReq)
+			}
+		} else {
+			data, errMarshal := json.Marshal(item.Body)
+			if errMarshal != nil {
+				panic(errMarshal)
+			}
+			if raw, ok := item.Body.(string); ok {
+				data = []byte(raw)
+			}
+			reqBody := bytes.NewReader(data)
+			var errNewReq error
+			req, errNewReq = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			if errNewReq != nil {
+				panic(errNewReq)
+			}
+			req.Header.Add("Content-Type", "application/json")
+		}
+		for name, value := range item.Headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", caseName, err)
+			continue
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("[%s] error readall: %v", caseName, err)
+			continue
+		}
+
+		// fmt.Printf("[%s] body: %s\n", caseName, string(body))
+		if item.Status == 0 {
+			item.Status = http.StatusOK
+		}
+
+		if resp.StatusCode != item.Status {
+			t.Fatalf("[%s] expected http status %v, got %v", caseName, item.Status, resp.StatusCode)
+			continue
+		}
+
+		// у 304 нет тела
+		if item.Status == http.StatusNotModified {
+			if len(body) != 0 {
+				t.Fatalf("[%s] expected empty body, got %s", caseName, body)
+			}
 			continue
 		}
 